@@ -0,0 +1,156 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Headers of the query requests holding the dashboard and the panel of the queries.
+const (
+	dashboardUIDHeader = "X-Dashboard-Uid"
+	panelIDHeader      = "X-Panel-Id"
+)
+
+// roleMapping maps the Grafana users of an organization and of a Grafana role to a
+// Vertica role. An empty organization or Grafana role matches all of them.
+type roleMapping struct {
+	OrgID       int64  `json:"orgId"`
+	GrafanaRole string `json:"grafanaRole"`
+	VerticaRole string `json:"verticaRole"`
+}
+
+// sessionAttribution is the client label and the role of the Vertica session running
+// the queries of a request, so that the queries can be attributed to a Grafana user.
+type sessionAttribution struct {
+	label string
+	role  string
+}
+
+// Function to validate the role mappings of the datasource settings.
+func validateRoleMappings(mappings []roleMapping) error {
+	for i, mapping := range mappings {
+		if strings.TrimSpace(mapping.VerticaRole) == "" {
+			return fmt.Errorf("invalid role mapping %d: the Vertica role is empty", i+1)
+		}
+	}
+	return nil
+}
+
+// Function to get the session attribution of a request from the Grafana user of the
+// request and the dashboard and panel headers. It is nil when neither the client
+// label nor the role mappings are configured.
+func newSessionAttribution(config configArgs, pluginContext backend.PluginContext, getHeader func(string) string) *sessionAttribution {
+	if !config.SetClientLabel && len(config.RoleMappings) == 0 {
+		return nil
+	}
+	attribution := &sessionAttribution{}
+	var login, grafanaRole string
+	if pluginContext.User != nil {
+		login, grafanaRole = pluginContext.User.Login, pluginContext.User.Role
+	}
+
+	if config.SetClientLabel {
+		parts := []string{"grafana", "org=" + strconv.FormatInt(pluginContext.OrgID, 10)}
+		if login != "" {
+			parts = append(parts, "user="+login)
+		}
+		if dashboardUID := getHeader(dashboardUIDHeader); dashboardUID != "" {
+			parts = append(parts, "dashboard="+dashboardUID)
+		}
+		if panelID := getHeader(panelIDHeader); panelID != "" {
+			parts = append(parts, "panel="+panelID)
+		}
+		attribution.label = strings.Join(parts, " ")
+	}
+
+	// The first matching mapping sets the role, the default roles of the user of the
+	// datasource are used when none matches.
+	for _, mapping := range config.RoleMappings {
+		if (mapping.OrgID == 0 || mapping.OrgID == pluginContext.OrgID) &&
+			(mapping.GrafanaRole == "" || strings.EqualFold(mapping.GrafanaRole, grafanaRole)) {
+			attribution.role = mapping.VerticaRole
+			break
+		}
+	}
+	return attribution
+}
+
+// Function to set the client label and the role of the session of a connection before
+// running a query. The returned function must be called once the query is completed,
+// to reset the session before the connection is returned to the pool.
+func (attribution *sessionAttribution) apply(ctx context.Context, connection *sql.Conn) (func(), error) {
+	if attribution == nil {
+		return func() {}, nil
+	}
+	if attribution.label != "" {
+		if _, err := connection.ExecContext(ctx, "SELECT SET_CLIENT_LABEL(?)", attribution.label); err != nil {
+			return nil, fmt.Errorf("error while setting the client label: %w", err)
+		}
+	}
+	if attribution.role != "" {
+		if _, err := connection.ExecContext(ctx, "SET ROLE "+quoteIdentifier(attribution.role)); err != nil {
+			resetSession(connection, attribution.label != "", false)
+			return nil, fmt.Errorf("error while setting the role %s: %w", attribution.role, err)
+		}
+	}
+	return func() { resetSession(connection, attribution.label != "", attribution.role != "") }, nil
+}
+
+// Function to reset the client label and the role of a session. The connection is
+// discarded when its role could not be reset, so that the role is not used by the
+// next queries of the pool. The reset is bounded by the interrupt timeout, so that a
+// dead connection doesn't block the query.
+func resetSession(connection *sql.Conn, label bool, role bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), interruptTimeout)
+	defer cancel()
+	if label {
+		if _, err := connection.ExecContext(ctx, "SELECT SET_CLIENT_LABEL('')"); err != nil {
+			log.DefaultLogger.Warn("Error while resetting the client label: " + err.Error())
+		}
+	}
+	if role {
+		if _, err := connection.ExecContext(ctx, "SET ROLE DEFAULT"); err != nil {
+			log.DefaultLogger.Warn("Error while resetting the role, discarding the connection: " + err.Error())
+			connection.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}
+}
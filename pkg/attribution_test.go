@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewSessionAttribution(t *testing.T) {
+
+	fmt.Println("Session Attribution Tests")
+
+	mappings := []roleMapping{
+		{OrgID: 2, VerticaRole: "tenant_two"},
+		{GrafanaRole: "Admin", VerticaRole: "dbduser"},
+		{OrgID: 1, GrafanaRole: "Viewer", VerticaRole: "analyst"},
+	}
+	headers := map[string]string{dashboardUIDHeader: "cpu-usage", panelIDHeader: "4"}
+	alice := &backend.User{Login: "alice", Role: "Viewer"}
+
+	tests := []struct {
+		name          string
+		config        configArgs
+		pluginContext backend.PluginContext
+		headers       map[string]string
+		expected      *sessionAttribution
+	}{
+		{
+			name:          "No attribution",
+			config:        configArgs{},
+			pluginContext: backend.PluginContext{OrgID: 1, User: alice},
+			headers:       headers,
+		},
+		{
+			name:          "Client label of a panel",
+			config:        configArgs{SetClientLabel: true},
+			pluginContext: backend.PluginContext{OrgID: 1, User: alice},
+			headers:       headers,
+			expected:      &sessionAttribution{label: "grafana org=1 user=alice dashboard=cpu-usage panel=4"},
+		},
+		{
+			name:          "Client label without dashboard",
+			config:        configArgs{SetClientLabel: true},
+			pluginContext: backend.PluginContext{OrgID: 1, User: alice},
+			expected:      &sessionAttribution{label: "grafana org=1 user=alice"},
+		},
+		{
+			name:          "Role of the organization and Grafana role",
+			config:        configArgs{SetClientLabel: true, RoleMappings: mappings},
+			pluginContext: backend.PluginContext{OrgID: 1, User: alice},
+			headers:       headers,
+			expected:      &sessionAttribution{label: "grafana org=1 user=alice dashboard=cpu-usage panel=4", role: "analyst"},
+		},
+		{
+			name:          "Role of the organization",
+			config:        configArgs{RoleMappings: mappings},
+			pluginContext: backend.PluginContext{OrgID: 2, User: &backend.User{Login: "bob", Role: "Admin"}},
+			expected:      &sessionAttribution{role: "tenant_two"},
+		},
+		{
+			name:          "Role of the Grafana role",
+			config:        configArgs{RoleMappings: mappings},
+			pluginContext: backend.PluginContext{OrgID: 3, User: &backend.User{Login: "bob", Role: "admin"}},
+			expected:      &sessionAttribution{role: "dbduser"},
+		},
+		{
+			name:          "No matching role",
+			config:        configArgs{RoleMappings: mappings},
+			pluginContext: backend.PluginContext{OrgID: 3, User: &backend.User{Login: "carol", Role: "Editor"}},
+			expected:      &sessionAttribution{},
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			req := &backend.QueryDataRequest{PluginContext: tc.pluginContext, Headers: tc.headers}
+			attribution := newSessionAttribution(tc.config, tc.pluginContext, req.GetHTTPHeader)
+			require.Equal(tt, tc.expected, attribution, "Invalid session attribution")
+		})
+	}
+}
+
+func Test_ValidateRoleMappings(t *testing.T) {
+
+	fmt.Println("Role Mappings Validation Tests")
+
+	require.Nil(t, validateRoleMappings([]roleMapping{{OrgID: 1, VerticaRole: "analyst"}}))
+	require.EqualError(t, validateRoleMappings([]roleMapping{{OrgID: 1, VerticaRole: "analyst"}, {GrafanaRole: "Admin"}}), "invalid role mapping 2: the Vertica role is empty")
+}
+
+func Test_Query_SessionAttribution(t *testing.T) {
+
+	fmt.Println("Query Session Attribution Tests")
+
+	tests := []struct {
+		name          string
+		attribution   *sessionAttribution
+		roleErr       error
+		resetRoleErr  error
+		expectedError string
+	}{
+		{
+			name:        "Client label and role are set and reset",
+			attribution: &sessionAttribution{label: "grafana org=1 user=alice", role: `data "science"`},
+		},
+		{
+			name:          "Role which cannot be set",
+			attribution:   &sessionAttribution{label: "grafana org=1 user=alice", role: "analyst"},
+			roleErr:       errors.New("Role \"analyst\" was not granted to the user"),
+			expectedError: "error while setting the role analyst: Role \"analyst\" was not granted to the user",
+		},
+		{
+			name:         "Connection is discarded when the role cannot be reset",
+			attribution:  &sessionAttribution{role: "analyst"},
+			resetRoleErr: errors.New("connection reset"),
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.Nil(tt, err)
+			defer db.Close()
+
+			if tc.attribution.label != "" {
+				mock.ExpectExec("SELECT SET_CLIENT_LABEL(?)").WithArgs(tc.attribution.label).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			setRole := mock.ExpectExec("SET ROLE " + quoteIdentifier(tc.attribution.role))
+			if tc.roleErr != nil {
+				setRole.WillReturnError(tc.roleErr)
+			} else {
+				setRole.WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT current_user()").WillReturnRows(sqlmock.NewRows([]string{"user"}).AddRow("alice"))
+			}
+			if tc.attribution.label != "" {
+				mock.ExpectExec("SELECT SET_CLIENT_LABEL('')").WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			if tc.roleErr == nil {
+				resetRole := mock.ExpectExec("SET ROLE DEFAULT")
+				if tc.resetRoleErr != nil {
+					resetRole.WillReturnError(tc.resetRoleErr)
+				} else {
+					resetRole.WillReturnResult(sqlmock.NewResult(0, 0))
+				}
+			}
+			if tc.resetRoleErr != nil {
+				mock.ExpectClose()
+			}
+
+			v := &VerticaDatasource{}
+			queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT current_user()", Format: "table"}), &instanceSettings{Db: db}, tc.attribution)
+
+			if tc.expectedError != "" {
+				require.EqualError(tt, queryOutput.Error, tc.expectedError, "Invalid error")
+			} else {
+				require.Nil(tt, queryOutput.Error, "Attributed query shouldn't throw any errors")
+			}
+			if tc.resetRoleErr != nil {
+				require.Equal(tt, 0, db.Stats().OpenConnections, "The connection should be discarded")
+			}
+			require.Nil(tt, mock.ExpectationsWereMet(), "Client label and role should be set and reset")
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Timeout of the statements used to interrupt a cancelled query.
+const interruptTimeout = 10 * time.Second
+
+// Maximum number of connections of the pool interrupting the cancelled queries.
+const maxInterruptConnections = 2
+
+// Function to fetch the Vertica session of a connection, used to interrupt the
+// statements running in that session.
+func currentSessionID(ctx context.Context, connection *sql.Conn) (string, error) {
+	var sessionID string
+	err := connection.QueryRowContext(ctx, "SELECT session_id FROM v_monitor.current_session").Scan(&sessionID)
+	return sessionID, err
+}
+
+// Maximum number of connections whose Vertica session is cached.
+const maxCachedSessions = 1000
+
+// sessionCache caches the Vertica sessions of the connections of a datasource by their
+// driver connection, so that the session of a connection is fetched by its first query
+// only, rather than by every query which can be cancelled.
+type sessionCache struct {
+	mu       sync.Mutex
+	sessions map[interface{}]string
+}
+
+// Function to create the session cache of a datasource.
+func newSessionCache() *sessionCache {
+	return &sessionCache{sessions: make(map[interface{}]string)}
+}
+
+// Function to get the Vertica session of a connection, fetched when it is not cached.
+// A nil cache fetches the session every time.
+func (cache *sessionCache) sessionID(ctx context.Context, connection *sql.Conn) (string, error) {
+	if cache == nil {
+		return currentSessionID(ctx, connection)
+	}
+	var driverConn interface{}
+	if err := connection.Raw(func(dc interface{}) error {
+		driverConn = dc
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	cache.mu.Lock()
+	sessionID, ok := cache.sessions[driverConn]
+	cache.mu.Unlock()
+	if ok {
+		return sessionID, nil
+	}
+
+	sessionID, err := currentSessionID(ctx, connection)
+	if err != nil {
+		return "", err
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	// The closed connections are not removed from the cache, which is cleared instead
+	// when it is full, the sessions of the open connections being fetched again.
+	if len(cache.sessions) >= maxCachedSessions {
+		cache.sessions = make(map[interface{}]string)
+	}
+	cache.sessions[driverConn] = sessionID
+	return sessionID, nil
+}
+
+// Function to watch the context of a query running in a Vertica session and interrupt the
+// running statement from the interrupt pool when the context is cancelled. The returned
+// function must be called once the query is completed, before the connection of the session
+// is released, and waits for a pending interrupt to complete.
+func watchCancellation(ctx context.Context, interruptDb *sql.DB, sessionID string) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			interruptSession(interruptDb, sessionID)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Function to interrupt the statement running in a Vertica session, closing the session
+// when the statement could not be interrupted.
+func interruptSession(interruptDb *sql.DB, sessionID string) {
+
+	log.DefaultLogger.Debug("Inside cancel.interruptSession Function")
+
+	ctx, cancel := context.WithTimeout(context.Background(), interruptTimeout)
+	defer cancel()
+
+	var statementID sql.NullInt64
+	err := interruptDb.QueryRowContext(ctx, "SELECT statement_id FROM v_monitor.sessions WHERE session_id = ?", sessionID).Scan(&statementID)
+	if err == sql.ErrNoRows || (err == nil && !statementID.Valid) {
+		// Nothing is running in the session anymore.
+		return
+	}
+	if err == nil {
+		_, err = interruptDb.ExecContext(ctx, "SELECT INTERRUPT_STATEMENT(?, ?)", sessionID, statementID.Int64)
+	}
+	if err == nil {
+		log.DefaultLogger.Info("Interrupted the statement of the cancelled query", "session", sessionID, "statement", statementID.Int64)
+		return
+	}
+
+	log.DefaultLogger.Warn("Could not interrupt the statement of the cancelled query, closing the session", "session", sessionID, "err", err)
+	// The interrupt may have failed on its deadline, the session is closed with a new one.
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), interruptTimeout)
+	defer closeCancel()
+	if _, err := interruptDb.ExecContext(closeCtx, "SELECT CLOSE_SESSION(?)", sessionID); err != nil {
+		log.DefaultLogger.Error("Could not close the session of the cancelled query", "session", sessionID, "err", err)
+	}
+}
+
+// Function to configure a pool of connections dedicated to interrupting the cancelled
+// queries. It is not limited by the pool running the queries, which is usually full
+// of the connections of the queries to interrupt.
+func configureInterruptPool(db *sql.DB) *sql.DB {
+	db.SetMaxOpenConns(maxInterruptConnections)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxIdleTime(time.Minute)
+	return db
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Query_Cancellation(t *testing.T) {
+
+	fmt.Println("Query Cancellation Tests")
+
+	tests := []struct {
+		name         string
+		interruptErr error
+		expectClose  bool
+	}{
+		{
+			name: "Cancelled query is interrupted",
+		},
+		{
+			name:         "Session is closed when the statement cannot be interrupted",
+			interruptErr: errors.New("Permission denied"),
+			expectClose:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.Nil(tt, err)
+			defer db.Close()
+			interruptDb, interruptMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.Nil(tt, err)
+			defer interruptDb.Close()
+
+			mock.ExpectQuery("SELECT session_id FROM v_monitor.current_session").WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow("v_node0001-1234:0x56"))
+			mock.ExpectQuery("SELECT * FROM big_table").WillDelayFor(time.Minute).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+			interruptMock.ExpectQuery("SELECT statement_id FROM v_monitor.sessions WHERE session_id = ?").WithArgs("v_node0001-1234:0x56").WillReturnRows(sqlmock.NewRows([]string{"statement_id"}).AddRow(7))
+			interrupt := interruptMock.ExpectExec("SELECT INTERRUPT_STATEMENT(?, ?)").WithArgs("v_node0001-1234:0x56", 7)
+			if tc.interruptErr != nil {
+				interrupt.WillReturnError(tc.interruptErr)
+			} else {
+				interrupt.WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			if tc.expectClose {
+				interruptMock.ExpectExec("SELECT CLOSE_SESSION(?)").WithArgs("v_node0001-1234:0x56").WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+
+			v := &VerticaDatasource{}
+			queryOutput := v.query(ctx, getDataQuery(queryModel{RawSQL: "SELECT * FROM big_table", Format: "table"}), &instanceSettings{Db: db, interruptDb: interruptDb}, nil)
+
+			require.NotNil(tt, queryOutput.Error, "Cancelled query should set an error")
+			require.Nil(tt, mock.ExpectationsWereMet(), "Session of the query should be tracked")
+			require.Nil(tt, interruptMock.ExpectationsWereMet(), "Running statement should be interrupted")
+		})
+	}
+}
+
+func Test_Query_Cancellation_Full_Pool(t *testing.T) {
+
+	fmt.Println("Query Cancellation Full Pool Tests")
+
+	const inFlight = 2
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.Nil(t, err)
+	defer db.Close()
+	interruptDb, interruptMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.Nil(t, err)
+	defer interruptDb.Close()
+	mock.MatchExpectationsInOrder(false)
+	interruptMock.MatchExpectationsInOrder(false)
+
+	// Every connection of the pool is held by a running query.
+	db.SetMaxOpenConns(inFlight)
+	for i := 0; i < inFlight; i++ {
+		sessionID := fmt.Sprintf("v_node0001-1234:0x5%d", i)
+		mock.ExpectQuery("SELECT session_id FROM v_monitor.current_session").WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow(sessionID))
+		mock.ExpectQuery("SELECT * FROM big_table").WillDelayFor(time.Minute).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+		interruptMock.ExpectQuery("SELECT statement_id FROM v_monitor.sessions WHERE session_id = ?").WithArgs(sessionID).WillReturnRows(sqlmock.NewRows([]string{"statement_id"}).AddRow(7))
+		interruptMock.ExpectExec("SELECT INTERRUPT_STATEMENT(?, ?)").WithArgs(sessionID, 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	v := &VerticaDatasource{}
+	instance := &instanceSettings{Db: db, interruptDb: interruptDb}
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < inFlight; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queryOutput := v.query(ctx, getDataQuery(queryModel{RawSQL: "SELECT * FROM big_table", Format: "table"}), instance, nil)
+			require.NotNil(t, queryOutput.Error, "Cancelled query should set an error")
+		}()
+	}
+	wg.Wait()
+
+	require.Less(t, time.Since(started), interruptTimeout, "Interrupts should not wait for the connections of the queries")
+	require.Nil(t, interruptMock.ExpectationsWereMet(), "Running statements should be interrupted")
+}
+
+func Test_Query_Cancellation_Session_Cache(t *testing.T) {
+
+	fmt.Println("Query Cancellation Session Cache Tests")
+
+	var queries []string
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(recordQueries(&queries)))
+	require.Nil(t, err)
+	defer db.Close()
+	interruptDb, _, err := sqlmock.New()
+	require.Nil(t, err)
+	defer interruptDb.Close()
+
+	// The connections of a sqlmock database share the same driver connection, so the
+	// session is cached after the first query.
+	mock.ExpectQuery("SELECT session_id FROM v_monitor.current_session").WillReturnRows(sqlmock.NewRows([]string{"session_id"}).AddRow("v_node0001-1234:0x56"))
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	v := &VerticaDatasource{}
+	instance := &instanceSettings{Db: db, interruptDb: interruptDb, sessions: newSessionCache()}
+	for i := 0; i < 2; i++ {
+		queryOutput := v.query(ctx, getDataQuery(queryModel{RawSQL: "SELECT 1", Format: "table"}), instance, nil)
+		require.Nil(t, queryOutput.Error, "Query should not fail")
+	}
+
+	require.Equal(t, []string{"SELECT session_id FROM v_monitor.current_session", "SELECT 1", "SELECT 1"}, queries, "Session should be fetched once for the connection")
+	require.Nil(t, mock.ExpectationsWereMet(), "Session should be fetched by the first query")
+}
+
+func Test_Query_Cancellation_Not_Cancellable(t *testing.T) {
+
+	fmt.Println("Query Cancellation Not Cancellable Tests")
+
+	var queries []string
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(recordQueries(&queries)))
+	require.Nil(t, err)
+	defer db.Close()
+	interruptDb, _, err := sqlmock.New()
+	require.Nil(t, err)
+	defer interruptDb.Close()
+
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+
+	v := &VerticaDatasource{}
+	queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT 1", Format: "table"}), &instanceSettings{Db: db, interruptDb: interruptDb, sessions: newSessionCache()}, nil)
+
+	require.Nil(t, queryOutput.Error, "Query should not fail")
+	require.Equal(t, []string{"SELECT 1"}, queries, "Session should not be fetched for a query which cannot be cancelled")
+	require.Nil(t, mock.ExpectationsWereMet(), "Only the query should run")
+}
+
+// Function to match the queries exactly like sqlmock.QueryMatcherEqual, recording every
+// query run, including the unexpected ones which sqlmock rejects without failing the test.
+func recordQueries(queries *[]string) sqlmock.QueryMatcher {
+	return sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+		*queries = append(*queries, actualSQL)
+		return sqlmock.QueryMatcherEqual.Match(expectedSQL, actualSQL)
+	})
+}
@@ -32,7 +32,7 @@ func Test_CheckHealth(t *testing.T) {
 		{
 			name:          "Success in connecting the Vertica DB",
 			ctx:           context.Background(),
-			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testUrl",BackupServerNode:"host1:port1,host2:port", UsePreparedStmts: false, UseLoadBalancer: false, MaxOpenConnections: 2, MaxIdealConnections: 2}),
+			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testUrl", BackupServerNode: "host1:port1,host2:port", UsePreparedStmts: false, UseLoadBalancer: false, MaxOpenConnections: 2, MaxIdealConnections: 2}),
 			expectedStatus: &backend.CheckHealthResult{
 				Status:  backend.HealthStatusOk,
 				Message: "Successfully connected to Vertica Analytic Database v10.1.0-0",
@@ -0,0 +1,290 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Prefixes of the type names of the Vertica complex types.
+var complexTypePrefixes = []string{"ARRAY", "SET", "ROW"}
+
+// Function to check whether a type name is the one of an ARRAY, SET or ROW column.
+func isComplexType(typeName string) bool {
+	typeName = strings.ToUpper(typeName)
+	for _, prefix := range complexTypePrefixes {
+		if strings.HasPrefix(typeName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to check whether a string value is a complex value. Vertica returns the
+// ARRAY and SET values as JSON arrays, and the ROW values as JSON objects.
+func isComplexValue(value string) bool {
+	value = strings.TrimSpace(value)
+	return (strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{")) && json.Valid([]byte(value))
+}
+
+// Function to convert a value of a complex column to JSON. Values which are not
+// valid JSON are kept as JSON strings.
+func complexValue(scanned scannedValue, value interface{}) (json.RawMessage, error) {
+	if scanned.Type == "STRING" || scanned.Type == "UNKNOWN" {
+		if json.Valid([]byte(scanned.String)) {
+			return json.RawMessage(scanned.String), nil
+		}
+		return json.Marshal(scanned.String)
+	}
+	return json.Marshal(value)
+}
+
+// Function to expand the JSON fields of a frame. Arrays are exploded into one row
+// per element, the other fields being repeated, and objects are exploded into one
+// field per member, named after the field and the member.
+func expandComplexFields(frame *data.Frame, maxRows int) (*data.Frame, bool) {
+	truncated := false
+	for idx := 0; idx < len(frame.Fields); idx++ {
+		if frame.Fields[idx].Type() != data.FieldTypeNullableJSON {
+			continue
+		}
+		for hasJSONArrays(frame.Fields[idx]) {
+			var rowsTruncated bool
+			frame, rowsTruncated = explodeRows(frame, idx, maxRows)
+			truncated = truncated || rowsTruncated
+		}
+		if fields := explodeObject(frame.Fields[idx]); fields != nil {
+			frame.Fields = append(frame.Fields[:idx], append(fields, frame.Fields[idx+1:]...)...)
+			// The members may be complex values as well.
+			idx--
+		}
+	}
+	return frame, truncated
+}
+
+// Function to check whether a JSON field holds at least one array.
+func hasJSONArrays(field *data.Field) bool {
+	for i := 0; i < field.Len(); i++ {
+		if raw, ok := field.At(i).(*json.RawMessage); ok && raw != nil && jsonKind(*raw) == '[' {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to explode the arrays of the field at idx into one row per element, up
+// to maxRows rows when it is positive. It reports whether rows were left out.
+func explodeRows(frame *data.Frame, idx int, maxRows int) (*data.Frame, bool) {
+	exploded := data.NewFrame(frame.Name)
+	exploded.Meta = frame.Meta
+	for _, field := range frame.Fields {
+		newField := data.NewFieldFromFieldType(field.Type(), 0)
+		newField.Name, newField.Labels, newField.Config = field.Name, field.Labels, field.Config
+		exploded.Fields = append(exploded.Fields, newField)
+	}
+
+	appendRow := func(row int, value *json.RawMessage) {
+		for i, field := range frame.Fields {
+			if i == idx {
+				exploded.Fields[i].Append(value)
+			} else {
+				exploded.Fields[i].Append(field.CopyAt(row))
+			}
+		}
+	}
+
+	for row := 0; row < frame.Rows(); row++ {
+		if maxRows > 0 && exploded.Rows() >= maxRows {
+			return exploded, true
+		}
+		raw, _ := frame.Fields[idx].At(row).(*json.RawMessage)
+		var elements []json.RawMessage
+		if raw == nil || jsonKind(*raw) != '[' || json.Unmarshal(*raw, &elements) != nil {
+			appendRow(row, raw)
+			continue
+		}
+		if len(elements) == 0 {
+			appendRow(row, nil)
+			continue
+		}
+		for i := range elements {
+			if maxRows > 0 && exploded.Rows() >= maxRows {
+				return exploded, true
+			}
+			appendRow(row, &elements[i])
+		}
+	}
+	return exploded, false
+}
+
+// Function to explode a JSON field holding only objects into one field per member.
+// It returns nil when the field holds other values.
+func explodeObject(field *data.Field) []*data.Field {
+	var names []string
+	members := make([]map[string]json.RawMessage, field.Len())
+	for i := 0; i < field.Len(); i++ {
+		raw, _ := field.At(i).(*json.RawMessage)
+		if raw == nil || jsonKind(*raw) == 'n' {
+			continue
+		}
+		if jsonKind(*raw) != '{' {
+			return nil
+		}
+		keys, values, err := objectMembers(*raw)
+		if err != nil {
+			return nil
+		}
+		for _, key := range keys {
+			if !contains(names, key) {
+				names = append(names, key)
+			}
+		}
+		members[i] = values
+	}
+	if names == nil {
+		return nil
+	}
+
+	fields := make([]*data.Field, 0, len(names))
+	for _, name := range names {
+		values := make([]json.RawMessage, field.Len())
+		for i := range members {
+			values[i] = members[i][name]
+		}
+		fields = append(fields, jsonValuesField(field.Name+"."+name, values))
+	}
+	return fields
+}
+
+// Function to create a field from JSON values, typed after the values. Numbers,
+// strings and booleans get their own field type, the other values stay JSON.
+func jsonValuesField(name string, values []json.RawMessage) *data.Field {
+	kind := byte(0)
+	for _, value := range values {
+		valueKind := jsonKind(value)
+		if valueKind == 0 || valueKind == 'n' {
+			continue
+		}
+		if valueKind == 'f' {
+			valueKind = 't'
+		}
+		if kind != 0 && kind != valueKind {
+			kind = '?'
+			break
+		}
+		kind = valueKind
+	}
+
+	switch kind {
+	case '0':
+		numbers := make([]*float64, len(values))
+		for i, value := range values {
+			var number float64
+			if jsonKind(value) == '0' && json.Unmarshal(value, &number) == nil {
+				numbers[i] = &number
+			}
+		}
+		return data.NewField(name, nil, numbers)
+	case '"':
+		strs := make([]*string, len(values))
+		for i, value := range values {
+			var str string
+			if jsonKind(value) == '"' && json.Unmarshal(value, &str) == nil {
+				strs[i] = &str
+			}
+		}
+		return data.NewField(name, nil, strs)
+	case 't':
+		bools := make([]*bool, len(values))
+		for i, value := range values {
+			var b bool
+			if kind := jsonKind(value); (kind == 't' || kind == 'f') && json.Unmarshal(value, &b) == nil {
+				bools[i] = &b
+			}
+		}
+		return data.NewField(name, nil, bools)
+	default:
+		raws := make([]*json.RawMessage, len(values))
+		for i := range values {
+			if kind := jsonKind(values[i]); kind != 0 && kind != 'n' {
+				raws[i] = &values[i]
+			}
+		}
+		return data.NewField(name, nil, raws)
+	}
+}
+
+// Function to decode the members of a JSON object, keeping their order.
+func objectMembers(raw json.RawMessage) ([]string, map[string]json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+	var keys []string
+	values := map[string]json.RawMessage{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := values[key]; !ok {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	return keys, values, nil
+}
+
+// Function to get the kind of a JSON value from its first character: '{', '[', '"',
+// 't' or 'f' for booleans, 'n' for null, '0' for numbers, and 0 when empty.
+func jsonKind(raw json.RawMessage) byte {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return 0
+	}
+	switch c := raw[0]; c {
+	case '{', '[', '"', 't', 'f', 'n':
+		return c
+	default:
+		return '0'
+	}
+}
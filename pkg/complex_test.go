@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+// Function to get the values of a field, dereferencing the JSON values to strings.
+func fieldValues(field *data.Field) []interface{} {
+	values := make([]interface{}, field.Len())
+	for i := range values {
+		switch v := field.At(i).(type) {
+		case *json.RawMessage:
+			if v != nil {
+				values[i] = string(*v)
+			}
+		case *float64:
+			if v != nil {
+				values[i] = *v
+			}
+		case *string:
+			if v != nil {
+				values[i] = *v
+			}
+		case *bool:
+			if v != nil {
+				values[i] = *v
+			}
+		case *int64:
+			if v != nil {
+				values[i] = *v
+			}
+		}
+	}
+	return values
+}
+
+func Test_Query_ComplexTypes(t *testing.T) {
+
+	fmt.Println("Query with Complex Types Tests")
+
+	tests := []struct {
+		name           string
+		expand         bool
+		expectedFields []string
+		expectedValues [][]interface{}
+	}{
+		{
+			name:           "Complex values as JSON fields",
+			expectedFields: []string{"id", "tags", "address", "scores"},
+			expectedValues: [][]interface{}{
+				{int64(1), int64(2)},
+				{`["a","b"]`, `[]`},
+				{`{"city":"Pune","zip":411001,"main":true}`, nil},
+				{`[1.5,2]`, `[3]`},
+			},
+		},
+		{
+			name:           "Complex values expanded",
+			expand:         true,
+			expectedFields: []string{"id", "tags", "address.city", "address.zip", "address.main", "scores"},
+			expectedValues: [][]interface{}{
+				{int64(1), int64(1), int64(1), int64(1), int64(2)},
+				{`"a"`, `"a"`, `"b"`, `"b"`, nil},
+				{"Pune", "Pune", "Pune", "Pune", nil},
+				{411001.0, 411001.0, 411001.0, 411001.0, nil},
+				{true, true, true, true, nil},
+				{`1.5`, `2`, `1.5`, `2`, `3`},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.Nil(tt, err)
+			defer db.Close()
+
+			mockRows := mock.NewRowsWithColumnDefinition(
+				mock.NewColumn("id").OfType("INT", 0),
+				mock.NewColumn("tags").OfType("ARRAY[VARCHAR]", ""),
+				mock.NewColumn("address").OfType("unknown column type oid: 300", ""),
+				mock.NewColumn("scores").OfType("SET[FLOAT]", ""),
+			).AddRow(1, `["a","b"]`, `{"city":"Pune","zip":411001,"main":true}`, `[1.5,2]`).AddRow(2, `[]`, nil, `[3]`)
+			mock.ExpectQuery("SELECT id, tags, address, scores FROM test_table").WillReturnRows(mockRows)
+
+			v := &VerticaDatasource{}
+			queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT id, tags, address, scores FROM test_table", Format: "table", ExpandComplexTypes: tc.expand}), &instanceSettings{Db: db}, nil)
+
+			require.Nil(tt, queryOutput.Error, "Query shouldn't throw any errors")
+			fields := queryOutput.Frames[0].Fields
+			require.Len(tt, fields, len(tc.expectedFields), "Invalid number of fields created")
+			for i, field := range fields {
+				require.Equal(tt, tc.expectedFields[i], field.Name, "Invalid field name")
+				require.Equal(tt, tc.expectedValues[i], fieldValues(field), "Invalid values of field "+field.Name)
+			}
+		})
+	}
+}
+
+func Test_IsComplexValue(t *testing.T) {
+
+	fmt.Println("Complex Value Detection Tests")
+
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{value: `[1,2,3]`, expected: true},
+		{value: ` {"a":[1]} `, expected: true},
+		{value: `[1,2`, expected: false},
+		{value: `{not json}`, expected: false},
+		{value: `42`, expected: false},
+		{value: `"text"`, expected: false},
+		{value: ``, expected: false},
+	}
+
+	for _, tc := range tests {
+		require.Equal(t, tc.expected, isComplexValue(tc.value), "Invalid detection of "+tc.value)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// scannedValue is a value scanned from the driver, converted to the Go type of its
+// value type (INTEGER, FLOAT, STRING, TIME, BOOLEAN, NULL or UNKNOWN).
+type scannedValue struct {
+	Type   string
+	Int    int64
+	Float  float64
+	String string
+	Time   time.Time
+	Bool   bool
+}
+
+// Function to convert a value scanned from the driver. Every value is converted
+// without panicking, the values of an unexpected type are UNKNOWN and keep their
+// string representation.
+func convertValue(value interface{}) scannedValue {
+	switch v := value.(type) {
+	case nil:
+		return scannedValue{Type: "NULL"}
+	case time.Time:
+		return scannedValue{Type: "TIME", Time: v}
+	case []byte:
+		return scannedValue{Type: "STRING", String: string(v)}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return scannedValue{Type: "INTEGER", Int: i}
+		}
+		if f, err := v.Float64(); err == nil {
+			return scannedValue{Type: "FLOAT", Float: f}
+		}
+		return scannedValue{Type: "STRING", String: v.String()}
+	case *big.Int:
+		if v == nil {
+			return scannedValue{Type: "NULL"}
+		}
+		if v.IsInt64() {
+			return scannedValue{Type: "INTEGER", Int: v.Int64()}
+		}
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return scannedValue{Type: "FLOAT", Float: f}
+	case *big.Float:
+		if v == nil {
+			return scannedValue{Type: "NULL"}
+		}
+		f, _ := v.Float64()
+		return scannedValue{Type: "FLOAT", Float: f}
+	case *big.Rat:
+		if v == nil {
+			return scannedValue{Type: "NULL"}
+		}
+		f, _ := v.Float64()
+		return scannedValue{Type: "FLOAT", Float: f}
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return scannedValue{Type: "NULL"}
+		}
+		return convertValue(rv.Elem().Interface())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return scannedValue{Type: "INTEGER", Int: rv.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := rv.Uint(); u <= math.MaxInt64 {
+			return scannedValue{Type: "INTEGER", Int: int64(u)}
+		}
+		return scannedValue{Type: "FLOAT", Float: float64(rv.Uint())}
+	case reflect.Float32:
+		// Going through the shortest representation keeps 0.1 from becoming 0.10000000149011612.
+		f, _ := strconv.ParseFloat(strconv.FormatFloat(rv.Float(), 'g', -1, 32), 64)
+		return scannedValue{Type: "FLOAT", Float: f}
+	case reflect.Float64:
+		return scannedValue{Type: "FLOAT", Float: rv.Float()}
+	case reflect.Bool:
+		return scannedValue{Type: "BOOLEAN", Bool: rv.Bool()}
+	case reflect.String:
+		return scannedValue{Type: "STRING", String: rv.String()}
+	}
+
+	if s, ok := value.(fmt.Stringer); ok {
+		return scannedValue{Type: "STRING", String: s.String()}
+	}
+	return scannedValue{Type: "UNKNOWN", String: fmt.Sprintf("%v", value)}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+type testStringer struct{}
+
+func (testStringer) String() string {
+	return "stringer"
+}
+
+func Test_ConvertValue(t *testing.T) {
+
+	fmt.Println("Value Conversion Tests")
+
+	now := time.Date(2021, 01, 05, 12, 00, 00, 00, time.UTC)
+	answer := int64(42)
+	var nilInt *int64
+	hugeInt, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected scannedValue
+	}{
+		{name: "nil", value: nil, expected: scannedValue{Type: "NULL"}},
+		{name: "int", value: int(-7), expected: scannedValue{Type: "INTEGER", Int: -7}},
+		{name: "int8", value: int8(-8), expected: scannedValue{Type: "INTEGER", Int: -8}},
+		{name: "int16", value: int16(16), expected: scannedValue{Type: "INTEGER", Int: 16}},
+		{name: "int32", value: int32(math.MaxInt32), expected: scannedValue{Type: "INTEGER", Int: math.MaxInt32}},
+		{name: "int64", value: int64(math.MinInt64), expected: scannedValue{Type: "INTEGER", Int: math.MinInt64}},
+		{name: "uint", value: uint(7), expected: scannedValue{Type: "INTEGER", Int: 7}},
+		{name: "uint8", value: uint8(255), expected: scannedValue{Type: "INTEGER", Int: 255}},
+		{name: "uint16", value: uint16(65535), expected: scannedValue{Type: "INTEGER", Int: 65535}},
+		{name: "uint32", value: uint32(math.MaxUint32), expected: scannedValue{Type: "INTEGER", Int: math.MaxUint32}},
+		{name: "uint64", value: uint64(math.MaxInt64), expected: scannedValue{Type: "INTEGER", Int: math.MaxInt64}},
+		{name: "uint64 overflowing int64", value: uint64(math.MaxUint64), expected: scannedValue{Type: "FLOAT", Float: float64(uint64(math.MaxUint64))}},
+		{name: "float32", value: float32(0.1), expected: scannedValue{Type: "FLOAT", Float: 0.1}},
+		{name: "float64", value: 2.5, expected: scannedValue{Type: "FLOAT", Float: 2.5}},
+		{name: "infinite float32", value: float32(math.Inf(1)), expected: scannedValue{Type: "FLOAT", Float: math.Inf(1)}},
+		{name: "bool", value: true, expected: scannedValue{Type: "BOOLEAN", Bool: true}},
+		{name: "string", value: "text", expected: scannedValue{Type: "STRING", String: "text"}},
+		{name: "bytes", value: []byte("bytes"), expected: scannedValue{Type: "STRING", String: "bytes"}},
+		{name: "empty bytes", value: []byte{}, expected: scannedValue{Type: "STRING"}},
+		{name: "time", value: now, expected: scannedValue{Type: "TIME", Time: now}},
+		{name: "time pointer", value: &now, expected: scannedValue{Type: "TIME", Time: now}},
+		{name: "int pointer", value: &answer, expected: scannedValue{Type: "INTEGER", Int: 42}},
+		{name: "nil pointer", value: nilInt, expected: scannedValue{Type: "NULL"}},
+		{name: "json integer", value: json.Number("12"), expected: scannedValue{Type: "INTEGER", Int: 12}},
+		{name: "json float", value: json.Number("1.5e3"), expected: scannedValue{Type: "FLOAT", Float: 1500}},
+		{name: "json invalid number", value: json.Number("abc"), expected: scannedValue{Type: "STRING", String: "abc"}},
+		{name: "big int", value: big.NewInt(-99), expected: scannedValue{Type: "INTEGER", Int: -99}},
+		{name: "huge big int", value: hugeInt, expected: scannedValue{Type: "FLOAT", Float: 1.2345678901234568e29}},
+		{name: "nil big int", value: (*big.Int)(nil), expected: scannedValue{Type: "NULL"}},
+		{name: "big float", value: big.NewFloat(3.25), expected: scannedValue{Type: "FLOAT", Float: 3.25}},
+		{name: "nil big float", value: (*big.Float)(nil), expected: scannedValue{Type: "NULL"}},
+		{name: "big rat", value: big.NewRat(1, 4), expected: scannedValue{Type: "FLOAT", Float: 0.25}},
+		{name: "nil big rat", value: (*big.Rat)(nil), expected: scannedValue{Type: "NULL"}},
+		{name: "named integer type", value: json.Delim('{'), expected: scannedValue{Type: "INTEGER", Int: '{'}},
+		{name: "stringer", value: testStringer{}, expected: scannedValue{Type: "STRING", String: "stringer"}},
+		{name: "stringer slice", value: net.IP{127, 0, 0, 1}, expected: scannedValue{Type: "STRING", String: "127.0.0.1"}},
+		{name: "unknown", value: []int{1, 2}, expected: scannedValue{Type: "UNKNOWN", String: "[1 2]"}},
+		{name: "unknown map", value: map[string]int{"a": 1}, expected: scannedValue{Type: "UNKNOWN", String: "map[a:1]"}},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			require.NotPanics(tt, func() {
+				require.Equal(tt, tc.expected, convertValue(tc.value), "Invalid converted value")
+			})
+		})
+	}
+}
+
+func Test_Query_DriverValueTypes(t *testing.T) {
+
+	fmt.Println("Query Driver Value Types Tests")
+
+	db, mock, err := sqlmock.New()
+	require.Nil(t, err)
+	defer db.Close()
+
+	mockRows := sqlmock.NewRows([]string{"small", "ratio", "raw", "flag"}).
+		AddRow(int32(3), float32(0.5), []byte("abc"), true).
+		AddRow(uint16(4), float32(1.5), []byte("def"), false)
+	mock.ExpectQuery("SELECT small, ratio, raw, flag FROM test_table").WillReturnRows(mockRows)
+
+	v := &VerticaDatasource{}
+	var queryOutput = v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT small, ratio, raw, flag FROM test_table", Format: "table"}), &instanceSettings{Db: db}, nil)
+
+	require.Nil(t, queryOutput.Error, "Query shouldn't throw any errors")
+	fields := queryOutput.Frames[0].Fields
+	require.Equal(t, int64(4), *fields[0].At(1).(*int64), "Invalid integer value")
+	require.Equal(t, 1.5, *fields[1].At(1).(*float64), "Invalid float value")
+	require.Equal(t, "abc", *fields[2].At(0).(*string), "Invalid bytes value")
+	require.Equal(t, true, *fields[3].At(0).(*bool), "Invalid boolean value")
+	require.Equal(t, false, *fields[3].At(1).(*bool), "Invalid boolean value")
+}
@@ -0,0 +1,174 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"strings"
+)
+
+// customMacro is a macro defined in the settings of the datasource. The SQL of the
+// macro is its expansion, in which the $param placeholders are replaced by the
+// arguments of the macro call, e.g. a tenantFilter macro with the col parameter
+// and the "$col IN (SELECT id FROM tenants)" SQL.
+type customMacro struct {
+	Name   string   `json:"name"`
+	Params []string `json:"params"`
+	SQL    string   `json:"sql"`
+}
+
+// customMacroPlaceholder is a $param placeholder of the SQL of a custom macro, from
+// its start to its end offset.
+type customMacroPlaceholder struct {
+	start int
+	end   int
+	name  string
+}
+
+// Function to validate the custom macros of the datasource settings and index them
+// by their macro name, i.e. their name with the __ prefix.
+func compileCustomMacros(macros []customMacro) (map[string]customMacro, error) {
+	compiled := make(map[string]customMacro, len(macros))
+	for _, macro := range macros {
+		macro.Name = strings.TrimPrefix(strings.TrimPrefix(macro.Name, "$"), "__")
+		if err := macro.validate(); err != nil {
+			return nil, fmt.Errorf("invalid custom macro $__%v: %w", macro.Name, err)
+		}
+		name := "__" + macro.Name
+		if contains(builtinMacros, name) {
+			return nil, fmt.Errorf("invalid custom macro $%v: a built-in macro has the same name", name)
+		}
+		if _, ok := compiled[name]; ok {
+			return nil, fmt.Errorf("invalid custom macro $%v: defined more than once", name)
+		}
+		compiled[name] = macro
+	}
+	return compiled, nil
+}
+
+// Function to check the name, the parameters and the SQL of a custom macro.
+func (macro customMacro) validate() error {
+	if !isIdentifier(macro.Name) {
+		return fmt.Errorf("the name should be made of letters, digits and underscores")
+	}
+	if strings.TrimSpace(macro.SQL) == "" {
+		return fmt.Errorf("the SQL is empty")
+	}
+	for i, param := range macro.Params {
+		if !isIdentifier(param) || strings.HasPrefix(param, "__") {
+			return fmt.Errorf("invalid parameter name %q", param)
+		}
+		if contains(macro.Params[:i], param) {
+			return fmt.Errorf("parameter %v is defined more than once", param)
+		}
+	}
+	placeholders, err := findPlaceholders(macro.SQL)
+	if err != nil {
+		return fmt.Errorf("invalid SQL: %w", positionedError(macro.SQL, err))
+	}
+	for _, placeholder := range placeholders {
+		// Built-in macros may be used in the SQL, they are expanded by the next pass.
+		if !strings.HasPrefix(placeholder.name, "__") && !contains(macro.Params, placeholder.name) {
+			return fmt.Errorf("the SQL uses the undefined parameter $%v", placeholder.name)
+		}
+	}
+	return nil
+}
+
+// Function to expand a call of a custom macro, replacing the placeholders of its
+// SQL by the arguments of the call.
+func (macro customMacro) expand(args []string) (string, error) {
+	if len(args) != len(macro.Params) {
+		return "", fmt.Errorf("macro $__%v expects %d arguments, got %d", macro.Name, len(macro.Params), len(args))
+	}
+	placeholders, err := findPlaceholders(macro.SQL)
+	if err != nil {
+		return "", err
+	}
+	var expanded strings.Builder
+	last := 0
+	for _, placeholder := range placeholders {
+		for i, param := range macro.Params {
+			if placeholder.name == param {
+				expanded.WriteString(macro.SQL[last:placeholder.start])
+				expanded.WriteString(args[i])
+				last = placeholder.end
+				break
+			}
+		}
+	}
+	expanded.WriteString(macro.SQL[last:])
+	return expanded.String(), nil
+}
+
+// Function to find the $param placeholders of the SQL of a custom macro, skipping the
+// string literals, quoted identifiers, comments and dollar quoted strings.
+func findPlaceholders(sql string) ([]customMacroPlaceholder, error) {
+	var placeholders []customMacroPlaceholder
+	for i := 0; i < len(sql); {
+		end, err := skipLiteral(sql, i)
+		if err != nil {
+			return nil, err
+		}
+		if end >= 0 {
+			i = end
+			continue
+		}
+		if sql[i] == '$' && (i == 0 || !isIdentifierChar(sql[i-1])) {
+			end = i + 1
+			for end < len(sql) && sql[end] != '$' && isIdentifierChar(sql[end]) {
+				end++
+			}
+			if end > i+1 {
+				placeholders = append(placeholders, customMacroPlaceholder{start: i, end: end, name: sql[i+1 : end]})
+				i = end
+				continue
+			}
+		}
+		i++
+	}
+	return placeholders, nil
+}
+
+// Function to check that a name is a non empty SQL identifier.
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] == '$' || !isIdentifierChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/stretchr/testify/require"
+)
+
+var tenantFilterMacro = customMacro{Name: "tenantFilter", Params: []string{"col"}, SQL: "$col IN (SELECT tenant_id FROM tenants WHERE active)"}
+
+func Test_CompileCustomMacros(t *testing.T) {
+
+	fmt.Println("Custom Macros Validation Tests")
+
+	tests := []struct {
+		name         string
+		macros       []customMacro
+		expected     []string
+		expectingErr error
+	}{
+		{
+			name:     "No custom macros",
+			expected: []string{},
+		},
+		{
+			name: "Valid custom macros",
+			macros: []customMacro{
+				tenantFilterMacro,
+				{Name: "$__recent", Params: []string{"col", "days"}, SQL: "$col > $__timeFrom() - INTERVAL '1 day' * $days"},
+				{Name: "partitionHint", SQL: "/*+ PROJS('public.events_p') */"},
+				{Name: "costNote", Params: []string{"col"}, SQL: "$col = 'cost $5' -- $total\n AND note <> $tag$ $price $tag$ AND \"$amount\" > 0"},
+			},
+			expected: []string{"__tenantFilter", "__recent", "__partitionHint", "__costNote"},
+		},
+		{
+			name:         "Unterminated string literal",
+			macros:       []customMacro{{Name: "tenantFilter", Params: []string{"col"}, SQL: "$col = 'cost"}},
+			expectingErr: fmt.Errorf("invalid custom macro $__tenantFilter: invalid SQL: unterminated string literal at line 1 col 8"),
+		},
+		{
+			name:         "Invalid name",
+			macros:       []customMacro{{Name: "tenant-filter", SQL: "1 = 1"}},
+			expectingErr: fmt.Errorf("invalid custom macro $__tenant-filter: the name should be made of letters, digits and underscores"),
+		},
+		{
+			name:         "Empty SQL",
+			macros:       []customMacro{{Name: "tenantFilter", Params: []string{"col"}, SQL: " "}},
+			expectingErr: fmt.Errorf("invalid custom macro $__tenantFilter: the SQL is empty"),
+		},
+		{
+			name:         "Invalid parameter name",
+			macros:       []customMacro{{Name: "tenantFilter", Params: []string{"my col"}, SQL: "1 = 1"}},
+			expectingErr: fmt.Errorf(`invalid custom macro $__tenantFilter: invalid parameter name "my col"`),
+		},
+		{
+			name:         "Duplicated parameter",
+			macros:       []customMacro{{Name: "tenantFilter", Params: []string{"col", "col"}, SQL: "$col = 1"}},
+			expectingErr: fmt.Errorf("invalid custom macro $__tenantFilter: parameter col is defined more than once"),
+		},
+		{
+			name:         "Undefined parameter",
+			macros:       []customMacro{{Name: "tenantFilter", Params: []string{"col"}, SQL: "$column = 1"}},
+			expectingErr: fmt.Errorf("invalid custom macro $__tenantFilter: the SQL uses the undefined parameter $column"),
+		},
+		{
+			name:         "Built-in macro name",
+			macros:       []customMacro{{Name: "timeFilter", Params: []string{"col"}, SQL: "$col > now()"}},
+			expectingErr: fmt.Errorf("invalid custom macro $__timeFilter: a built-in macro has the same name"),
+		},
+		{
+			name:         "Duplicated macro",
+			macros:       []customMacro{tenantFilterMacro, tenantFilterMacro},
+			expectingErr: fmt.Errorf("invalid custom macro $__tenantFilter: defined more than once"),
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			compiled, err := compileCustomMacros(tc.macros)
+
+			if tc.expectingErr != nil {
+				require.EqualError(tt, err, tc.expectingErr.Error(), "Invalid error")
+				return
+			}
+			require.Nil(tt, err, "Valid custom macros shouldn't throw any errors")
+			require.Len(tt, compiled, len(tc.expected), "Invalid number of custom macros")
+			for _, name := range tc.expected {
+				require.Contains(tt, compiled, name, "Missing custom macro")
+			}
+		})
+	}
+}
+
+func Test_Macros_CustomMacros(t *testing.T) {
+
+	fmt.Println("Custom Macros Expansion Tests")
+
+	customMacros, err := compileCustomMacros([]customMacro{
+		tenantFilterMacro,
+		{Name: "recentFilter", Params: []string{"col"}, SQL: "$__timeFilter($col) AND $__tenantFilter(tenant_id)"},
+		{Name: "pair", Params: []string{"a", "b"}, SQL: "($b, $a)"},
+		{Name: "loop", SQL: "$__loop"},
+		{Name: "costNote", Params: []string{"col"}, SQL: "$col = 'cost $col' /* $col */ AND $col <> $tag$$col$tag$"},
+	})
+	require.Nil(t, err, "Valid custom macros shouldn't throw any errors")
+	instance := &instanceSettings{customMacros: customMacros}
+
+	tests := []struct {
+		name          string
+		rawSQL        string
+		expectedQuery string
+		expectingErr  error
+	}{
+		{
+			name:          "Custom macro with a parameter",
+			rawSQL:        "SELECT * FROM events WHERE $__tenantFilter(events.tenant_id)",
+			expectedQuery: "SELECT * FROM events WHERE events.tenant_id IN (SELECT tenant_id FROM tenants WHERE active)",
+		},
+		{
+			name:          "Custom macro using built-in and custom macros",
+			rawSQL:        "SELECT * FROM events WHERE $__recentFilter(event_time)",
+			expectedQuery: "SELECT * FROM events WHERE event_time BETWEEN '2021-01-01T12:00:00Z' AND '2021-01-25T12:00:00Z' AND tenant_id IN (SELECT tenant_id FROM tenants WHERE active)",
+		},
+		{
+			name:          "Custom macro with several parameters",
+			rawSQL:        "SELECT $__pair(coalesce(a, 0), 'b, c')",
+			expectedQuery: "SELECT ('b, c', coalesce(a, 0))",
+		},
+		{
+			name:          "Custom macro with placeholders in literals and comments",
+			rawSQL:        "SELECT * FROM events WHERE $__costNote(note)",
+			expectedQuery: "SELECT * FROM events WHERE note = 'cost $col' /* $col */ AND note <> $tag$$col$tag$",
+		},
+		{
+			name:         "Custom macro with a wrong number of arguments",
+			rawSQL:       "SELECT * FROM events WHERE $__tenantFilter(a, b)",
+			expectingErr: fmt.Errorf("macro $__tenantFilter expects 1 arguments, got 2 at line 1 col 28"),
+		},
+		{
+			name:         "Recursive custom macro",
+			rawSQL:       "SELECT $__loop",
+			expectingErr: fmt.Errorf("macros nested more than 5 levels deep at line 1 col 8"),
+		},
+		{
+			name:         "Undefined macro",
+			rawSQL:       "SELECT $__unknown(a)",
+			expectingErr: fmt.Errorf("undefined macro: $____unknown at line 1 col 8"),
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			querySQL, _, err := sanitizeAndInterpolateMacros(tc.rawSQL, getDataQuery(queryModel{RawSQL: tc.rawSQL}), instance)
+
+			if tc.expectingErr != nil {
+				require.EqualError(tt, err, tc.expectingErr.Error(), "Invalid error")
+				require.Equal(tt, "", querySQL, "Query sanitization should result null if wrong query has been passed")
+			} else {
+				require.Nil(tt, err, "Correct query shouldn't throw any errors")
+				require.Equal(tt, tc.expectedQuery, querySQL, "After sanitization the Raw Query SQL should be same")
+			}
+		})
+	}
+}
+
+func Test_CheckHealth_InvalidCustomMacro(t *testing.T) {
+
+	fmt.Println("Custom Macros Check Health Tests")
+
+	v := &VerticaDatasource{im: datasource.NewInstanceManager(newDataSourceInstance)}
+	pluginContext := getCredentials(configArgs{URL: "testUrl", CustomMacros: []customMacro{{Name: "tenantFilter", SQL: "$col = 1"}}})
+
+	healthStatus, err := v.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: pluginContext})
+	require.Nil(t, err, "Check health shouldn't throw any errors")
+	require.Equal(t, backend.HealthStatusError, healthStatus.Status, "Health Status should be an Error")
+	require.Equal(t, "invalid custom macro $__tenantFilter: the SQL uses the undefined parameter $col", healthStatus.Message, "Health Message should be same")
+}
@@ -39,13 +39,18 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
-	"golang.org/x/net/proxy"
+
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 	vertica "github.com/vertica/vertica-sql-go"
+	"golang.org/x/net/proxy"
 )
 
 func newDatasource() datasource.ServeOpts {
@@ -57,8 +62,9 @@ func newDatasource() datasource.ServeOpts {
 		im: im,
 	}
 	return datasource.ServeOpts{
-		QueryDataHandler:   ds,
-		CheckHealthHandler: ds,
+		QueryDataHandler:    ds,
+		CheckHealthHandler:  ds,
+		CallResourceHandler: newResourceHandler(ds),
 	}
 }
 
@@ -95,46 +101,126 @@ type VerticaDatasource struct {
 // GetVerticaDb will return the vertica db connection
 // stored in the instance setting when the instance is created or update
 func (v *VerticaDatasource) GetVerticaDb(pluginContext backend.PluginContext) (*sql.DB, error) {
+	instanceSetting, err := v.getInstanceSettings(pluginContext)
+	if err != nil {
+		return nil, err
+	}
+	return instanceSetting.Db, nil
+}
+
+// getInstanceSettings will return the instance setting of the datasource
+// created when the instance is created or update
+func (v *VerticaDatasource) getInstanceSettings(pluginContext backend.PluginContext) (*instanceSettings, error) {
 	instance, err := v.im.Get(context.Background(), pluginContext)
 	if err != nil {
-		log.DefaultLogger.Error("getVerticaDb: %s", err)
+		log.DefaultLogger.Error("getInstanceSettings: %s", err)
 		return nil, err
 	}
 	if instanceSetting, ok := instance.(*instanceSettings); ok {
-		return instanceSetting.Db, nil
-	} else {
-		log.DefaultLogger.Error("getVerticaDb: %s", err)
-		return nil, err
+		return instanceSetting, nil
 	}
+	err = fmt.Errorf("unexpected instance type %T", instance)
+	log.DefaultLogger.Error("getInstanceSettings: %s", err)
+	return nil, err
+}
 
+type configArgs struct {
+	User                   string            `json:"user"`
+	Database               string            `json:"database"`
+	TLSMode                string            `json:"tlsmode"`
+	URL                    string            `json:"url"`
+	UseBackupServer        bool              `json:"useBackupserver"`
+	BackupServerNode       string            `json:"backupServerNode"`
+	OauthToken             string            `json:"OauthToken"`
+	UsePreparedStmts       bool              `json:"usePreparedStatements"`
+	UseLoadBalancer        bool              `json:"useLoadBalancer"`
+	MaxOpenConnections     int               `json:"maxOpenConnections"`
+	MaxIdealConnections    int               `json:"maxIdealConnections"`
+	MaxConnectionIdealTime int               `json:"maxConnectionIdealTime"`
+	MaxConcurrentQueries   int               `json:"maxConcurrentQueries"`
+	QueryTimeout           int               `json:"queryTimeout"`
+	MaxRows                int               `json:"maxRows"`
+	MaxResponseBytes       int64             `json:"maxResponseBytes"`
+	NumericAsString        bool              `json:"numericAsString"`
+	DatabaseTimezone       string            `json:"databaseTimezone"`
+	DriverParams           map[string]string `json:"driverParams"`
+	OauthTokenURL          string            `json:"oauthTokenUrl"`
+	OauthClientID          string            `json:"oauthClientId"`
+	OauthScopes            []string          `json:"oauthScopes"`
+	ForwardOauthIdentity   bool              `json:"oauthPassThru"`
+	MaxUserPools           int               `json:"maxUserPools"`
+	SetClientLabel         bool              `json:"setClientLabel"`
+	RoleMappings           []roleMapping     `json:"roleMappings"`
+	TLSServerName          string            `json:"tlsServerName"`
+	CustomMacros           []customMacro     `json:"customMacros"`
+	EnableSecureSocksProxy bool              `json:"enableSecureSocksProxy,omitempty"`
 }
 
+// Location returns the time zone of the TIMESTAMP columns of the database, nil
+// when none is configured.
+func (config *configArgs) Location() (*time.Location, error) {
+	if config.DatabaseTimezone == "" {
+		return nil, nil
+	}
+	location, err := time.LoadLocation(config.DatabaseTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database time zone %q: %w", config.DatabaseTimezone, err)
+	}
+	return location, nil
+}
 
-type configArgs struct {
-	User                   string `json:"user"`
-	Database               string `json:"database"`
-	TLSMode                string `json:"tlsmode"`
-	URL                    string `json:"url"`
-	UseBackupServer        bool   `json:"useBackupserver"`
-	BackupServerNode       string `json:"backupServerNode"`
-	OauthToken			   string `json:"OauthToken"`
-	UsePreparedStmts       bool   `json:"usePreparedStatements"`
-	UseLoadBalancer        bool   `json:"useLoadBalancer"`
-	MaxOpenConnections     int    `json:"maxOpenConnections"`
-	MaxIdealConnections    int    `json:"maxIdealConnections"`
-	MaxConnectionIdealTime int    `json:"maxConnectionIdealTime"`
-	EnableSecureSocksProxy bool   `json:"enableSecureSocksProxy,omitempty"`
+// Default number of queries of a request executed concurrently.
+const defaultMaxConcurrentQueries = 10
+
+// QueryConcurrency returns the number of queries of a request which may be executed
+// concurrently. It never exceeds the max open connections when those are limited.
+func (config *configArgs) QueryConcurrency() int {
+	concurrency := config.MaxConcurrentQueries
+	if concurrency <= 0 {
+		concurrency = defaultMaxConcurrentQueries
+	}
+	if config.MaxOpenConnections > 0 && concurrency > config.MaxOpenConnections {
+		concurrency = config.MaxOpenConnections
+	}
+	return concurrency
 }
 
 // ConnectionURL , generates a vertica connection URL for configArgs. Requires password as input.
-func (config *configArgs) ConnectionURL(password string , OauthToken string) string {
+// The credentials, the database and the parameters are escaped, so that they may hold any character.
+func (config *configArgs) ConnectionURL(password string, OauthToken string) string {
 	var tlsmode string
 	if config.TLSMode == "" {
 		tlsmode = "none"
 	} else {
 		tlsmode = config.TLSMode
 	}
-	return fmt.Sprintf("vertica://%s:%s@%s/%s?use_prepared_statements=%d&connection_load_balance=%d&tlsmode=%s&backup_server_node=%s&oauth_access_token=%s", config.User, password, config.URL, config.Database, boolTouint8(config.UsePreparedStmts), boolTouint8(config.UseLoadBalancer), tlsmode, config.BackupServerNode, OauthToken)
+
+	// The extra driver parameters cannot override the ones set by the settings of the datasource.
+	params := url.Values{}
+	for name, value := range config.DriverParams {
+		params.Set(name, value)
+	}
+	for name, value := range map[string]string{
+		"use_prepared_statements": strconv.Itoa(int(boolTouint8(config.UsePreparedStmts))),
+		"connection_load_balance": strconv.Itoa(int(boolTouint8(config.UseLoadBalancer))),
+		"tlsmode":                 tlsmode,
+		"backup_server_node":      config.BackupServerNode,
+		"oauth_access_token":      OauthToken,
+	} {
+		if _, ok := config.DriverParams[name]; ok {
+			log.DefaultLogger.Warn("Ignoring the driver parameter set by the datasource settings", "param", name)
+		}
+		params.Set(name, value)
+	}
+
+	connURL := url.URL{
+		Scheme:   "vertica",
+		User:     url.UserPassword(config.User, password),
+		Host:     config.URL,
+		Path:     "/" + config.Database,
+		RawQuery: params.Encode(),
+	}
+	return connURL.String()
 }
 
 type queryModel struct {
@@ -142,6 +228,17 @@ type queryModel struct {
 	Format       string `json:"format"`
 	RawSQL       string `json:"rawSql"`
 	RefID        string `json:"refId"`
+	QueryTimeout int    `json:"queryTimeout"`
+
+	// IntervalMs and MaxDataPoints are sent by the panel of the query.
+	IntervalMs    int64 `json:"intervalMs"`
+	MaxDataPoints int64 `json:"maxDataPoints"`
+
+	// Timezone is the time zone of the dashboard, an IANA name, browser or utc.
+	Timezone string `json:"timezone"`
+
+	// ExpandComplexTypes explodes the ARRAY, SET and ROW values into rows and fields.
+	ExpandComplexTypes bool `json:"expandComplexTypes"`
 }
 
 type sqlColumn struct {
@@ -166,6 +263,15 @@ type sqlColumn struct {
 
 	// StringData contains string values (if Type == "STRING")
 	StringData []*string
+
+	// JSONData contains the ARRAY, SET and ROW values (if Type == "JSON")
+	JSONData []*json.RawMessage
+
+	// DatabaseType is the Vertica type of the column.
+	DatabaseType string
+
+	// Config is the field config of the column type, if any.
+	Config *data.FieldConfig
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -180,11 +286,18 @@ func (v *VerticaDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 	response := backend.NewQueryDataResponse()
 
 	// Vertica db conntection
-	connDB, err := v.GetVerticaDb(req.PluginContext)
+	instance, err := v.getInstanceSettings(req.PluginContext)
 	if err != nil {
 		log.DefaultLogger.Error("Error while connecting to the Vertica Database: " + err.Error())
 		return response, err
 	}
+	instance, release, err := instance.forUser(req.PluginContext, req.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName))
+	if err != nil {
+		log.DefaultLogger.Error("Error while getting the connections of the user: " + err.Error())
+		return response, err
+	}
+	defer release()
+	connDB := instance.Db
 
 	if err = connDB.PingContext(context.Background()); err != nil {
 		log.DefaultLogger.Error("Error while connecting to the Vertica Database: " + err.Error())
@@ -193,74 +306,180 @@ func (v *VerticaDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 	// https://golang.org/pkg/database/sql/#DBStats
 	log.DefaultLogger.Debug(fmt.Sprintf("%s connection stats open connections =%d, InUse = %d, Ideal = %d", req.PluginContext.DataSourceInstanceSettings.Name, connDB.Stats().MaxOpenConnections, connDB.Stats().InUse, connDB.Stats().Idle))
 
-	// loop over queries and execute them individually.
-	for _, q := range req.Queries {
-		res := v.query(ctx, q, connDB)
+	// execute the queries concurrently, at most queryConcurrency at a time.
+	queryConcurrency := instance.queryConcurrency
+	if queryConcurrency <= 0 {
+		queryConcurrency = defaultMaxConcurrentQueries
+	}
+	// the queries of the request are attributed to the Grafana user.
+	attribution := newSessionAttribution(instance.config, req.PluginContext, req.GetHTTPHeader)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	slots := make(chan struct{}, queryConcurrency)
 
-		// save the response in a hashmap
-		// based on with RefID as identifier
-		response.Responses[q.RefID] = res
+	for _, q := range req.Queries {
+		wg.Add(1)
+		go func(q backend.DataQuery) {
+			defer wg.Done()
+
+			var res backend.DataResponse
+			select {
+			case slots <- struct{}{}:
+				if err := ctx.Err(); err != nil {
+					// the slot was freed as the request was cancelled.
+					res = backend.DataResponse{Error: err}
+				} else {
+					res = v.query(ctx, q, instance, attribution)
+				}
+				<-slots
+			case <-ctx.Done():
+				// the request was cancelled before the query could be started.
+				res = backend.DataResponse{Error: ctx.Err()}
+			}
+
+			// save the response in a hashmap
+			// based on with RefID as identifier
+			mu.Lock()
+			response.Responses[q.RefID] = res
+			mu.Unlock()
+		}(q)
 	}
+	wg.Wait()
 
 	return response, nil
 }
 
 type instanceSettings struct {
-	httpClient *http.Client
-	Db         *sql.DB
-	Name       string
+	httpClient       *http.Client
+	Db               *sql.DB
+	interruptDb      *sql.DB
+	sessions         *sessionCache
+	Name             string
+	config           configArgs
+	queryConcurrency int
+	databaseTimezone *time.Location
+	customMacros     map[string]customMacro
+	tlsState         *tlsState
+	userPools        *userPools
 }
 
 // Create new datasource.
 func newDataSourceInstance(ctx context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-// func newDataSourceInstance(setting backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+	// func newDataSourceInstance(setting backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
 	var config configArgs
 	secret := settings.DecryptedSecureJSONData["password"]
 	OauthToken := settings.DecryptedSecureJSONData["OauthToken"]
-	
+
 	err := json.Unmarshal(settings.JSONData, &config)
 	if err != nil {
 		return nil, err
 	}
-   
-	var db *sql.DB
+	databaseTimezone, err := config.Location()
+	if err != nil {
+		return nil, err
+	}
+	customMacros, err := compileCustomMacros(config.CustomMacros)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := newOauthTokenSource(config, settings.DecryptedSecureJSONData)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateRoleMappings(config.RoleMappings); err != nil {
+		return nil, err
+	}
+
 	proxyClient, err := settings.ProxyClient(ctx)
-		if err != nil {
-			return nil, err
-		}
+	if err != nil {
+		return nil, err
+	}
 
-	connStr := config.ConnectionURL(secret,OauthToken)
-	
+	// The connections use the TLS configuration registered for the datasource, if any.
+	state := &tlsState{}
+	dsnConfig := config
+	if dsnConfig.TLSMode, err = registerTLSConfig(settings, config, state); err != nil {
+		return nil, err
+	}
+	connStr := dsnConfig.ConnectionURL(secret, OauthToken)
 
-	if proxyClient.SecureSocksProxyEnabled() {
+	dialContext := (&net.Dialer{}).DialContext
+	proxyEnabled := proxyClient.SecureSocksProxyEnabled()
+	if proxyEnabled {
 		pdialer, err := proxyClient.NewSecureSocksProxyContextDialer()
 		if err != nil {
 			return nil, err
 		}
-	dialer := &dialContextWrapper{Dialer: pdialer}
-	connector, err := vertica.NewConnector(connStr,dialer.DialContext)
-	if err != nil {
-		return nil, err
+		dialer := &dialContextWrapper{Dialer: pdialer}
+		dialContext = dialer.DialContext
 	}
-	db = sql.OpenDB(connector)
-		
-	} else {
-		db, err = sql.Open("vertica", connStr)
+
+	// Function to open a pool of connections to the database. The queries run in one
+	// pool and the cancelled queries are interrupted from another one.
+	openDB := func() (*sql.DB, error) {
+		if tokens != nil {
+			// Each connection is opened with an access token refreshed by the token source.
+			return sql.OpenDB(&oauthConnector{config: dsnConfig, password: secret, tokens: tokens, dialContext: dialContext}), nil
+		}
+		if proxyEnabled {
+			connector, err := vertica.NewConnector(connStr, dialContext)
+			if err != nil {
+				return nil, err
+			}
+			return sql.OpenDB(connector), nil
+		}
+		db, err := sql.Open("vertica", connStr)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open Vertica connection: %w", err)
 		}
-	} 
-	
+		return db, nil
+	}
+	db, err := openDB()
+	if err != nil {
+		return nil, err
+	}
+	interruptDb, err := openDB()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// With the OAuth identity forwarded, the queries of each user run in a pool of the
+	// user, authenticated by the OAuth token of the user, which also provides the user
+	// name to Vertica.
+	var pools *userPools
+	if config.ForwardOauthIdentity {
+		userConfig := dsnConfig
+		userConfig.User = ""
+		pools = newUserPools(config.MaxUserPools, func(tokens accessTokenSource) (*sql.DB, *sql.DB) {
+			userDB := sql.OpenDB(&oauthConnector{config: userConfig, tokens: tokens, dialContext: dialContext})
+			userDB.SetMaxOpenConns(config.MaxOpenConnections)
+			userDB.SetMaxIdleConns(config.MaxIdealConnections)
+			userDB.SetConnMaxIdleTime(time.Minute * time.Duration(config.MaxConnectionIdealTime))
+			return userDB, configureInterruptPool(sql.OpenDB(&oauthConnector{config: userConfig, tokens: tokens, dialContext: dialContext}))
+		})
+	}
+
 	db.SetMaxOpenConns(config.MaxOpenConnections)
 	db.SetMaxIdleConns(config.MaxIdealConnections)
 	db.SetConnMaxIdleTime(time.Minute * time.Duration(config.MaxConnectionIdealTime))
+	configureInterruptPool(interruptDb)
 	log.DefaultLogger.Info(fmt.Sprintf("newDataSourceInstance: new instance of datasource created: %+v", settings.Name))
 	return &instanceSettings{
-		httpClient: &http.Client{},
-		Db:         db,
-		Name:       settings.Name,
+		httpClient:       &http.Client{},
+		Db:               db,
+		interruptDb:      interruptDb,
+		sessions:         newSessionCache(),
+		Name:             settings.Name,
+		config:           config,
+		queryConcurrency: config.QueryConcurrency(),
+		databaseTimezone: databaseTimezone,
+		customMacros:     customMacros,
+		tlsState:         state,
+		userPools:        pools,
 	}, nil
-	
+
 }
 
 // CheckHealth handles health checks sent from Grafana to the plugin.
@@ -272,7 +491,7 @@ func (v *VerticaDatasource) CheckHealth(ctx context.Context, req *backend.CheckH
 	log.DefaultLogger.Debug("Inside datasource.CheckHealth Function", "request", req)
 
 	var status = backend.HealthStatusOk
-	connDB, err := v.GetVerticaDb(req.PluginContext)
+	instance, err := v.getInstanceSettings(req.PluginContext)
 
 	if err != nil {
 		log.DefaultLogger.Error("unable to get sql.DB connection: " + err.Error())
@@ -281,6 +500,16 @@ func (v *VerticaDatasource) CheckHealth(ctx context.Context, req *backend.CheckH
 			Message: fmt.Sprintf("%s", err),
 		}, nil
 	}
+	instance, release, err := instance.forUser(req.PluginContext, req.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName))
+	if err != nil {
+		log.DefaultLogger.Error("unable to get the connections of the user: " + err.Error())
+		return &backend.CheckHealthResult{
+			Status:  backend.HealthStatusError,
+			Message: fmt.Sprintf("%s", err),
+		}, nil
+	}
+	defer release()
+	connDB := instance.Db
 	// https://golang.org/pkg/database/sql/#DBStats
 	log.DefaultLogger.Debug(fmt.Sprintf("%s connection stats open connections =%d, InUse = %d, Ideal = %d", req.PluginContext.DataSourceInstanceSettings.Name, connDB.Stats().MaxOpenConnections, connDB.Stats().InUse, connDB.Stats().Idle))
 	connection, err := connDB.Conn(ctx)
@@ -327,9 +556,13 @@ func (v *VerticaDatasource) CheckHealth(ctx context.Context, req *backend.CheckH
 		}
 	}
 
+	message := fmt.Sprintf("Successfully connected to %s", queryResult)
+	if description := instance.tlsState.describe(); description != "" {
+		message += fmt.Sprintf(" using %s", description)
+	}
 	return &backend.CheckHealthResult{
 		Status:  status,
-		Message: fmt.Sprintf("Successfully connected to %s", queryResult),
+		Message: message,
 	}, nil
 }
 
@@ -338,5 +571,9 @@ func (s *instanceSettings) Dispose() {
 	// to cleanup.
 	log.DefaultLogger.Debug("%s connection stats open connections =%d, InUse = %d, Ideal = %d", s.Name, s.Db.Stats().MaxOpenConnections, s.Db.Stats().InUse, s.Db.Stats().Idle)
 	s.Db.Close()
+	s.interruptDb.Close()
+	if s.userPools != nil {
+		s.userPools.close()
+	}
 	log.DefaultLogger.Info(fmt.Sprintf("db connections of datasource %s closed", s.Name))
 }
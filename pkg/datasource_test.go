@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_QueryConcurrency(t *testing.T) {
+
+	fmt.Println("Query Concurrency Tests")
+
+	tests := []struct {
+		name     string
+		config   configArgs
+		expected int
+	}{
+		{
+			name:     "Default concurrency",
+			config:   configArgs{},
+			expected: defaultMaxConcurrentQueries,
+		},
+		{
+			name:     "Configured concurrency",
+			config:   configArgs{MaxConcurrentQueries: 4},
+			expected: 4,
+		},
+		{
+			name:     "Concurrency bounded by max open connections",
+			config:   configArgs{MaxConcurrentQueries: 8, MaxOpenConnections: 3},
+			expected: 3,
+		},
+		{
+			name:     "Default concurrency bounded by max open connections",
+			config:   configArgs{MaxOpenConnections: 2},
+			expected: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(tt *testing.T) {
+			require.Equal(tt, tc.expected, tc.config.QueryConcurrency(), "Invalid query concurrency")
+		})
+	}
+}
+
+func Test_ConnectionURL(t *testing.T) {
+
+	fmt.Println("Connection URL Tests")
+
+	tests := []struct {
+		name           string
+		config         configArgs
+		password       string
+		oauthToken     string
+		expectedParams map[string]string
+	}{
+		{
+			name:     "Plain settings",
+			config:   configArgs{User: "dbadmin", Database: "VMart", URL: "localhost:5433", UsePreparedStmts: true},
+			password: "secret",
+			expectedParams: map[string]string{
+				"use_prepared_statements": "1",
+				"connection_load_balance": "0",
+				"tlsmode":                 "none",
+				"backup_server_node":      "",
+				"oauth_access_token":      "",
+			},
+		},
+		{
+			name:       "Hostile characters",
+			config:     configArgs{User: "john@corp:a/b?c", Database: "my db?x=1#frag", URL: "localhost:5433", TLSMode: "server", BackupServerNode: "host1:5433,host2:5433", UseLoadBalancer: true},
+			password:   "p@ss:w/rd?&x=1%20#+ ",
+			oauthToken: "tok&en=/+%?#",
+			expectedParams: map[string]string{
+				"use_prepared_statements": "0",
+				"connection_load_balance": "1",
+				"tlsmode":                 "server",
+				"backup_server_node":      "host1:5433,host2:5433",
+				"oauth_access_token":      "tok&en=/+%?#",
+			},
+		},
+		{
+			name:     "Extra driver parameters",
+			config:   configArgs{User: "dbadmin", Database: "VMart", URL: "localhost:5433", DriverParams: map[string]string{"client_label": "grafana & co", "autocommit": "0", "tlsmode": "server-strict"}},
+			password: "secret",
+			expectedParams: map[string]string{
+				"use_prepared_statements": "0",
+				"connection_load_balance": "0",
+				"tlsmode":                 "none",
+				"backup_server_node":      "",
+				"oauth_access_token":      "",
+				"client_label":            "grafana & co",
+				"autocommit":              "0",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			// The connection URL is parsed the same way as the vertica driver does.
+			connURL, err := url.Parse(tc.config.ConnectionURL(tc.password, tc.oauthToken))
+			require.Nil(tt, err, "Connection URL should be valid")
+			require.Equal(tt, "vertica", connURL.Scheme, "Invalid scheme")
+			require.Equal(tt, tc.config.URL, connURL.Host, "Invalid host")
+			require.Equal(tt, tc.config.User, connURL.User.Username(), "Invalid user")
+			password, _ := connURL.User.Password()
+			require.Equal(tt, tc.password, password, "Invalid password")
+			require.Equal(tt, tc.config.Database, strings.TrimLeft(connURL.Path, "/"), "Invalid database")
+			require.Empty(tt, connURL.Fragment, "Connection URL shouldn't have a fragment")
+
+			params := connURL.Query()
+			require.Len(tt, params, len(tc.expectedParams), "Invalid number of parameters")
+			for name, value := range tc.expectedParams {
+				require.Equal(tt, []string{value}, params[name], "Invalid parameter "+name)
+			}
+		})
+	}
+}
+
+func Test_DatabaseTimezone(t *testing.T) {
+
+	fmt.Println("Database Time Zone Tests")
+
+	tests := []struct {
+		name         string
+		config       configArgs
+		expected     string
+		expectingErr bool
+	}{
+		{
+			name:     "No database time zone",
+			config:   configArgs{},
+			expected: "",
+		},
+		{
+			name:     "Valid database time zone",
+			config:   configArgs{DatabaseTimezone: "America/New_York"},
+			expected: "America/New_York",
+		},
+		{
+			name:         "Invalid database time zone",
+			config:       configArgs{DatabaseTimezone: "Mars/Olympus"},
+			expectingErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			location, err := tc.config.Location()
+			if tc.expectingErr {
+				require.NotNil(tt, err, "Invalid time zone should throw an error")
+				return
+			}
+			require.Nil(tt, err, "Valid time zone shouldn't throw any errors")
+			if tc.expected == "" {
+				require.Nil(tt, location, "No time zone should be resolved")
+			} else {
+				require.Equal(tt, tc.expected, location.String(), "Invalid time zone")
+			}
+		})
+	}
+}
+
+func Test_QueryData_MultipleQueries(t *testing.T) {
+
+	fmt.Println("Multiple Queries Tests")
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.Nil(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	refIDs := []string{"A", "B", "C", "D", "E"}
+	queries := []backend.DataQuery{}
+	for i, refID := range refIDs {
+		rawSQL := fmt.Sprintf("SELECT %d", i)
+		if refID == "C" {
+			mock.ExpectQuery(rawSQL).WillReturnError(fmt.Errorf("sql error occurred"))
+		} else {
+			mock.ExpectQuery(rawSQL).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(i))
+		}
+		query := getDataQuery(queryModel{RawSQL: rawSQL, Format: "table"})
+		query.RefID = refID
+		queries = append(queries, query)
+	}
+
+	v := &VerticaDatasource{
+		im: datasource.NewInstanceManager(func(_ context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+			return &instanceSettings{Db: db, Name: settings.Name, queryConcurrency: 2}, nil
+		}),
+	}
+
+	resp, err := v.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: getCredentials(configArgs{URL: "testurl"}),
+		Queries:       queries,
+	})
+	require.Nil(t, err)
+	require.Len(t, resp.Responses, len(refIDs), "One response should be created per query")
+
+	for i, refID := range refIDs {
+		res := resp.Responses[refID]
+		if refID == "C" {
+			require.NotNil(t, res.Error, "Failed query should only set the error of its own response")
+			continue
+		}
+		require.Nil(t, res.Error)
+		require.Equal(t, fmt.Sprintf("SELECT %d", i), res.Frames[0].Meta.ExecutedQueryString, "Response should belong to its RefID")
+	}
+	require.Nil(t, mock.ExpectationsWereMet())
+}
+
+// Function to create a datasource with a running query concurrency, whose queries are
+// blocked in the interpolation of their macros until release is closed. The started
+// channel receives the number of running queries whenever a query starts.
+func newBlockingQueriesDatasource(t *testing.T, queryConcurrency int, started chan<- int, release <-chan struct{}) *VerticaDatasource {
+	db, _, err := sqlmock.New()
+	require.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	var mu sync.Mutex
+	running := 0
+	monkey.Patch(sanitizeAndInterpolateMacros, func(rawSQL string, _ backend.DataQuery, _ *instanceSettings) (string, *macroContext, error) {
+		mu.Lock()
+		running++
+		started <- running
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "", &macroContext{}, fmt.Errorf("query released")
+	})
+	t.Cleanup(monkey.UnpatchAll)
+
+	return &VerticaDatasource{
+		im: datasource.NewInstanceManager(func(_ context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+			return &instanceSettings{Db: db, Name: settings.Name, queryConcurrency: queryConcurrency}, nil
+		}),
+	}
+}
+
+// Function to create the queries of the given RefIDs.
+func getRefIDQueries(refIDs ...string) []backend.DataQuery {
+	var queries []backend.DataQuery
+	for _, refID := range refIDs {
+		query := getDataQuery(queryModel{RawSQL: "SELECT 1", Format: "table"})
+		query.RefID = refID
+		queries = append(queries, query)
+	}
+	return queries
+}
+
+func Test_QueryData_ConcurrencyLimit(t *testing.T) {
+
+	fmt.Println("Query Concurrency Limit Tests")
+
+	const queryConcurrency = 2
+
+	started := make(chan int, 10)
+	release := make(chan struct{})
+	v := newBlockingQueriesDatasource(t, queryConcurrency, started, release)
+	refIDs := []string{"A", "B", "C", "D", "E", "F"}
+
+	done := make(chan *backend.QueryDataResponse)
+	go func() {
+		resp, err := v.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: getCredentials(configArgs{URL: "testurl"}),
+			Queries:       getRefIDQueries(refIDs...),
+		})
+		require.Nil(t, err)
+		done <- resp
+	}()
+
+	// Only queryConcurrency queries start until the running ones complete.
+	for i := 0; i < queryConcurrency; i++ {
+		<-started
+	}
+	select {
+	case running := <-started:
+		t.Fatalf("%d queries are running, at most %d should", running, queryConcurrency)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+	resp := <-done
+	close(started)
+	for running := range started {
+		require.LessOrEqual(t, running, queryConcurrency, "Too many queries are running")
+	}
+	require.Len(t, resp.Responses, len(refIDs), "Every query should be run")
+}
+
+func Test_QueryData_Cancellation(t *testing.T) {
+
+	fmt.Println("Query Data Cancellation Tests")
+
+	started := make(chan int, 10)
+	release := make(chan struct{})
+	v := newBlockingQueriesDatasource(t, 1, started, release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan *backend.QueryDataResponse)
+	go func() {
+		resp, err := v.QueryData(ctx, &backend.QueryDataRequest{
+			PluginContext: getCredentials(configArgs{URL: "testurl"}),
+			Queries:       getRefIDQueries("A", "B", "C"),
+		})
+		require.Nil(t, err)
+		done <- resp
+	}()
+
+	// One query is running and the other ones are queued when the request is cancelled.
+	<-started
+	cancel()
+
+	close(release)
+	resp := <-done
+
+	queued := 0
+	for refID, res := range resp.Responses {
+		if res.Error != nil && res.Error.Error() == "query released" {
+			continue
+		}
+		require.ErrorIs(t, res.Error, context.Canceled, "Queued query "+refID+" should be cancelled")
+		queued++
+	}
+	require.Equal(t, 2, queued, "The queued queries should be cancelled")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Queries of real dashboards, used as the seed corpus of the macro fuzz target.
+var dashboardQueries = []string{
+	"SELECT \n  $__time(end_time), \n  average_cpu_usage_percent \n  FROM \n  v_monitor.cpu_usage \n  WHERE \n  $__timeFilter(end_time)  \n  ORDER BY  \n  time",
+	"SELECT \n  $__timeGroup(started,$__interval,NULL), \n  user_name AS metric,\n  count(user_key) - lag(count(user_key)) OVER (PARTITION BY user_name ORDER BY $__timeGroup(started,$__interval,NULL)) AS 'user_key' \n FROM public.intest\nWHERE\n  $__timeFilter(started) AND\n  user_key = value\nGROUP BY 1,2 \n ORDER BY 1,2",
+	"SELECT $__timeGroup(start_time, '5m', previous), node_name AS metric, avg(average_memory_usage_percent) FROM v_monitor.memory_usage WHERE $__timeFilter(start_time) GROUP BY 1, 2 ORDER BY 1",
+	"SELECT $__unixEpochGroup(epoch, auto, 0), sum(bytes) FROM public.traffic WHERE $__unixEpochFilter(epoch) GROUP BY 1 ORDER BY 1",
+	"SELECT * FROM orders WHERE client IN ($__expandMultiString('A','B')) AND created > $__timeFrom() AND created < $__timeTo()",
+	"SELECT request_id FROM v_monitor.query_requests WHERE start_timestamp > $__unixEpochNanoFrom() -- $__timeGroup(\n/* $__time( */",
+	"SELECT E'it\\'s', $$ $__timeTo $$, \"$__col\" FROM t WHERE $__unixEpochNanoFilter(ts) LIMIT $__maxDataPoints",
+	"SELECT $__time($__timeFrom()), $__interval_ms",
+}
+
+func FuzzSanitizeAndInterpolateMacros(f *testing.F) {
+	for _, query := range dashboardQueries {
+		f.Add(query, int64(30000), int64(1000))
+	}
+
+	f.Fuzz(func(t *testing.T, rawSQL string, intervalMs int64, maxDataPoints int64) {
+		dataQuery := getDataQuery(queryModel{RawSQL: rawSQL, IntervalMs: intervalMs, MaxDataPoints: maxDataPoints})
+
+		querySQL, macroCtx, err := sanitizeAndInterpolateMacros(rawSQL, dataQuery, &instanceSettings{})
+		if macroCtx == nil {
+			t.Fatalf("macro context should always be returned for %q", rawSQL)
+		}
+		if err != nil {
+			if querySQL != "" {
+				t.Fatalf("failed interpolation of %q should not return a query, got %q", rawSQL, querySQL)
+			}
+			return
+		}
+
+		// No macro may be left outside of the literals and comments of the query.
+		tokens, err := tokenizeMacros(querySQL)
+		if err != nil {
+			t.Fatalf("interpolated query %q of %q should be tokenized: %v", querySQL, rawSQL, err)
+		}
+		if len(tokens) != 0 {
+			t.Fatalf("interpolated query %q of %q still holds the macro %v", querySQL, rawSQL, tokens[0].name)
+		}
+
+		// A query without macros is left as is.
+		if !strings.Contains(rawSQL, "$__") && querySQL != rawSQL {
+			t.Fatalf("query %q without macros should be left as is, got %q", rawSQL, querySQL)
+		}
+
+		// The interpolated query is a fixed point of the interpolation.
+		again, _, err := sanitizeAndInterpolateMacros(querySQL, dataQuery, &instanceSettings{})
+		if err != nil || again != querySQL {
+			t.Fatalf("interpolating %q again should not change it, got %q, %v", querySQL, again, err)
+		}
+	})
+}
+
+func FuzzParseDuration(f *testing.F) {
+	for _, seed := range []string{"5m", "1h30m", "-1.5h", "300ms", "2d", "1w", "1M", "0", "+10s", ".5s", "1µs", "10us", "9223372036854775807ns", "1.0000000001h", "", "m", "1x"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, str string) {
+		duration, err := ParseDuration(str)
+		if err != nil {
+			return
+		}
+
+		// The durations of the time package units are the same as the ones of time.ParseDuration.
+		if !strings.ContainsAny(str, "dwM") {
+			expected, err := time.ParseDuration(str)
+			if err != nil {
+				t.Fatalf("%q is parsed as %v but rejected by time.ParseDuration: %v", str, duration, err)
+			}
+			if expected != duration {
+				t.Fatalf("%q is parsed as %v, time.ParseDuration gives %v", str, duration, expected)
+			}
+		}
+
+		// The string of the duration is parsed back to the same duration.
+		roundTrip, err := ParseDuration(duration.String())
+		if err != nil || roundTrip != duration {
+			t.Fatalf("%v parsed from %q is parsed back as %v, %v", duration, str, roundTrip, err)
+		}
+	})
+}
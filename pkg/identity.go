@@ -0,0 +1,203 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Default maximum number of connection pools of the users of a datasource forwarding
+// the OAuth identity of the users.
+const defaultMaxUserPools = 20
+
+// forwardedToken is the OAuth access token of a user, forwarded by Grafana with the
+// requests of the user. It is updated by each request, so that the new connections
+// of the user use the latest token.
+type forwardedToken struct {
+	mu    sync.Mutex
+	token string
+}
+
+// Token returns the latest access token of the user.
+func (t *forwardedToken) Token(_ context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token, nil
+}
+
+// Function to update the access token of the user.
+func (t *forwardedToken) set(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token
+}
+
+// userPool is the connection pool of a user.
+type userPool struct {
+	identity    string
+	db          *sql.DB
+	interruptDb *sql.DB
+	token       *forwardedToken
+
+	// refs is the number of requests using the pool, which is closed once it is
+	// evicted and no longer used.
+	refs    int
+	evicted bool
+}
+
+// Function to close the connections of a user pool.
+func (pool *userPool) close() {
+	pool.db.Close()
+	pool.interruptDb.Close()
+}
+
+// userPools holds the connection pools of the users of a datasource, at most maxPools
+// of them. The least recently used pool is evicted when a new user needs one.
+type userPools struct {
+	mu       sync.Mutex
+	maxPools int
+	open     func(tokens accessTokenSource) (*sql.DB, *sql.DB)
+	pools    map[string]*list.Element
+	lru      *list.List
+}
+
+// Function to create the user pools of a datasource, opening the pools with open,
+// which returns the pool of the queries and the one interrupting them.
+func newUserPools(maxPools int, open func(tokens accessTokenSource) (*sql.DB, *sql.DB)) *userPools {
+	if maxPools <= 0 {
+		maxPools = defaultMaxUserPools
+	}
+	return &userPools{
+		maxPools: maxPools,
+		open:     open,
+		pools:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Function to get the pool of a user, opening it when needed, and to update the
+// access token of the user. The pool must be released once the request is done.
+func (p *userPools) acquire(identity string, token string) *userPool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if element, ok := p.pools[identity]; ok {
+		p.lru.MoveToFront(element)
+		pool := element.Value.(*userPool)
+		pool.token.set(token)
+		pool.refs++
+		return pool
+	}
+
+	for p.lru.Len() >= p.maxPools {
+		oldest := p.lru.Back()
+		evicted := p.lru.Remove(oldest).(*userPool)
+		delete(p.pools, evicted.identity)
+		evicted.evicted = true
+		log.DefaultLogger.Debug("Evicting the connection pool of a user", "user", evicted.identity)
+		if evicted.refs == 0 {
+			evicted.close()
+		}
+	}
+
+	pool := &userPool{identity: identity, token: &forwardedToken{token: token}, refs: 1}
+	pool.db, pool.interruptDb = p.open(pool.token)
+	p.pools[identity] = p.lru.PushFront(pool)
+	return pool
+}
+
+// Function to release a pool acquired by a request, closing it when it was evicted
+// while in use.
+func (p *userPools) release(pool *userPool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pool.refs--
+	if pool.evicted && pool.refs == 0 {
+		pool.close()
+	}
+}
+
+// Function to close the pools of all the users.
+func (p *userPools) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for element := p.lru.Front(); element != nil; element = element.Next() {
+		pool := element.Value.(*userPool)
+		pool.evicted = true
+		if pool.refs == 0 {
+			pool.close()
+		}
+	}
+	p.pools = make(map[string]*list.Element)
+	p.lru.Init()
+}
+
+// Function to get the instance settings of a request forwarding the OAuth identity of
+// the user, which use the connection pool of the user. The returned function releases
+// the pool. The settings of the datasource are returned as is when the identity is
+// not forwarded. The requests without the bearer token of the user are rejected, rather
+// than using the credentials of the datasource.
+func (instance *instanceSettings) forUser(pluginContext backend.PluginContext, authorization string) (*instanceSettings, func(), error) {
+	if instance.userPools == nil {
+		return instance, func() {}, nil
+	}
+	if pluginContext.User == nil || pluginContext.User.Login == "" {
+		return nil, nil, fmt.Errorf("the OAuth identity is forwarded but the request has no Grafana user")
+	}
+	if authorization == "" {
+		return nil, nil, fmt.Errorf("the OAuth token of the user %s was not forwarded by Grafana", pluginContext.User.Login)
+	}
+	// Grafana forwards the OAuth token of the users signed in with OAuth as a bearer
+	// token, the basic and API key credentials of the other users are not tokens.
+	scheme, token, _ := strings.Cut(authorization, " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, nil, fmt.Errorf("the user %s has no OAuth session, the credentials forwarded by Grafana are not a bearer token", pluginContext.User.Login)
+	}
+
+	pool := instance.userPools.acquire(fmt.Sprintf("%d/%s", pluginContext.OrgID, pluginContext.User.Login), token)
+	userInstance := *instance
+	userInstance.Db = pool.db
+	userInstance.interruptDb = pool.interruptDb
+	return &userInstance, func() { instance.userPools.release(pool) }, nil
+}
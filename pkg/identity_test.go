@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/stretchr/testify/require"
+)
+
+// Function to create user pools opening sqlmock databases, recording the token
+// sources of the opened pools.
+func newTestUserPools(t *testing.T, maxPools int) (*userPools, *[]accessTokenSource) {
+	var opened []accessTokenSource
+	pools := newUserPools(maxPools, func(tokens accessTokenSource) (*sql.DB, *sql.DB) {
+		db, _, err := sqlmock.New()
+		require.Nil(t, err)
+		interruptDb, _, err := sqlmock.New()
+		require.Nil(t, err)
+		opened = append(opened, tokens)
+		return db, interruptDb
+	})
+	return pools, &opened
+}
+
+// Function to check whether a pool was closed.
+func isClosed(pool *userPool) bool {
+	return pool.db.Ping() != nil
+}
+
+func Test_UserPools(t *testing.T) {
+
+	fmt.Println("User Connection Pools Tests")
+
+	t.Run("Least recently used pool is evicted", func(tt *testing.T) {
+		pools, opened := newTestUserPools(tt, 2)
+
+		alice := pools.acquire("1/alice", "alice-1")
+		pools.release(alice)
+		bob := pools.acquire("1/bob", "bob-1")
+		pools.release(bob)
+
+		// The pool of alice is reused with her new token and becomes the most recently used.
+		again := pools.acquire("1/alice", "alice-2")
+		pools.release(again)
+		require.Same(tt, alice, again, "The pool of the user should be reused")
+		token, _ := alice.token.Token(context.Background())
+		require.Equal(tt, "alice-2", token, "The token of the user should be updated")
+
+		carol := pools.acquire("1/carol", "carol-1")
+		pools.release(carol)
+		require.Len(tt, *opened, 3, "Invalid number of opened pools")
+		require.True(tt, isClosed(bob), "The least recently used pool should be closed")
+		require.False(tt, isClosed(alice), "The recently used pool should be kept")
+		require.False(tt, isClosed(carol), "The new pool should be kept")
+
+		pools.close()
+		require.True(tt, isClosed(alice), "The pools should be closed")
+		require.True(tt, isClosed(carol), "The pools should be closed")
+	})
+
+	t.Run("Pool evicted while in use is closed once released", func(tt *testing.T) {
+		pools, _ := newTestUserPools(tt, 1)
+
+		alice := pools.acquire("1/alice", "alice-1")
+		bob := pools.acquire("1/bob", "bob-1")
+		require.False(tt, isClosed(alice), "The pool in use shouldn't be closed")
+
+		pools.release(alice)
+		require.True(tt, isClosed(alice), "The evicted pool should be closed once released")
+		pools.release(bob)
+
+		// A new pool is opened when the user comes back.
+		again := pools.acquire("1/alice", "alice-2")
+		require.NotSame(tt, alice, again, "A new pool should be opened")
+		pools.release(again)
+		require.True(tt, isClosed(bob), "The pool of bob should be evicted")
+	})
+}
+
+func Test_ForUser(t *testing.T) {
+
+	fmt.Println("Forwarded OAuth Identity Tests")
+
+	shared, _, err := sqlmock.New()
+	require.Nil(t, err)
+	pools, opened := newTestUserPools(t, 2)
+	instance := &instanceSettings{Db: shared, userPools: pools}
+
+	tests := []struct {
+		name          string
+		instance      *instanceSettings
+		pluginContext backend.PluginContext
+		authorization string
+		expectedToken string
+		expectingErr  error
+	}{
+		{
+			name:          "Identity not forwarded",
+			instance:      &instanceSettings{Db: shared},
+			pluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "alice"}},
+		},
+		{
+			name:          "Forwarded token",
+			instance:      instance,
+			pluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "alice"}},
+			authorization: "Bearer alice-token",
+			expectedToken: "alice-token",
+		},
+		{
+			name:          "Request without user",
+			instance:      instance,
+			authorization: "Bearer alice-token",
+			expectingErr:  fmt.Errorf("the OAuth identity is forwarded but the request has no Grafana user"),
+		},
+		{
+			name:          "Lower case bearer token",
+			instance:      instance,
+			pluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "alice"}},
+			authorization: "bearer alice-token",
+			expectedToken: "alice-token",
+		},
+		{
+			name:          "Basic credentials",
+			instance:      instance,
+			pluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "bob"}},
+			authorization: "Basic Ym9iOnNlY3JldA==",
+			expectingErr:  fmt.Errorf("the user bob has no OAuth session, the credentials forwarded by Grafana are not a bearer token"),
+		},
+		{
+			name:          "Token without scheme",
+			instance:      instance,
+			pluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "bob"}},
+			authorization: "glsa_api_key",
+			expectingErr:  fmt.Errorf("the user bob has no OAuth session, the credentials forwarded by Grafana are not a bearer token"),
+		},
+		{
+			name:          "Bearer scheme without token",
+			instance:      instance,
+			pluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "bob"}},
+			authorization: "Bearer ",
+			expectingErr:  fmt.Errorf("the user bob has no OAuth session, the credentials forwarded by Grafana are not a bearer token"),
+		},
+		{
+			name:          "Request without token",
+			instance:      instance,
+			pluginContext: backend.PluginContext{OrgID: 1, User: &backend.User{Login: "alice"}},
+			expectingErr:  fmt.Errorf("the OAuth token of the user alice was not forwarded by Grafana"),
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			userInstance, release, err := tc.instance.forUser(tc.pluginContext, tc.authorization)
+			if tc.expectingErr != nil {
+				require.EqualError(tt, err, tc.expectingErr.Error(), "Invalid error")
+				return
+			}
+			require.Nil(tt, err, "Forwarded identity shouldn't throw any errors")
+			defer release()
+
+			if tc.expectedToken == "" {
+				require.Same(tt, tc.instance, userInstance, "The datasource settings should be used")
+				return
+			}
+			require.NotSame(tt, shared, userInstance.Db, "The pool of the user should be used")
+			token, _ := (*opened)[len(*opened)-1].Token(context.Background())
+			require.Equal(tt, tc.expectedToken, token, "Invalid token of the user")
+		})
+	}
+}
+
+func Test_QueryData_ForwardOauthIdentity(t *testing.T) {
+
+	fmt.Println("Forwarded OAuth Identity Query Tests")
+
+	// The shared pool has no expectation, the queries must run in the pool of the user.
+	shared, _, err := sqlmock.New()
+	require.Nil(t, err)
+	userDB, userMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual), sqlmock.MonitorPingsOption(true))
+	require.Nil(t, err)
+	userInterruptDb, _, err := sqlmock.New()
+	require.Nil(t, err)
+	userMock.ExpectPing()
+	userMock.ExpectQuery("SELECT current_user()").WillReturnRows(sqlmock.NewRows([]string{"user"}).AddRow("alice"))
+
+	var tokens []accessTokenSource
+	v := &VerticaDatasource{
+		im: datasource.NewInstanceManager(func(_ context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+			return &instanceSettings{Db: shared, Name: settings.Name, userPools: newUserPools(1, func(source accessTokenSource) (*sql.DB, *sql.DB) {
+				tokens = append(tokens, source)
+				return userDB, userInterruptDb
+			})}, nil
+		}),
+	}
+
+	query := getDataQuery(queryModel{RawSQL: "SELECT current_user()", Format: "table"})
+	query.RefID = "A"
+	pluginContext := getCredentials(configArgs{URL: "testurl", ForwardOauthIdentity: true})
+	pluginContext.OrgID = 1
+	pluginContext.User = &backend.User{Login: "alice"}
+
+	resp, err := v.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pluginContext,
+		Headers:       map[string]string{"Authorization": "Bearer alice-token"},
+		Queries:       []backend.DataQuery{query},
+	})
+	require.Nil(t, err)
+	require.Nil(t, resp.Responses["A"].Error, "Query of the user shouldn't throw any errors")
+	require.Nil(t, userMock.ExpectationsWereMet())
+	require.Len(t, tokens, 1, "One pool should be opened for the user")
+	token, _ := tokens[0].Token(context.Background())
+	require.Equal(t, "alice-token", token, "The pool should use the token of the user")
+
+	_, err = v.QueryData(context.Background(), &backend.QueryDataRequest{PluginContext: pluginContext, Queries: []backend.DataQuery{query}})
+	require.EqualError(t, err, "the OAuth token of the user alice was not forwarded by Grafana", "Requests without token should be rejected")
+}
+
+func Test_Resource_ForwardOauthIdentity(t *testing.T) {
+
+	fmt.Println("Forwarded OAuth Identity Resource Tests")
+
+	// The shared pool has no expectation, the catalog must be browsed in the pool of the user.
+	shared, _, err := sqlmock.New()
+	require.Nil(t, err)
+	userDB, userMock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.Nil(t, err)
+	userInterruptDb, _, err := sqlmock.New()
+	require.Nil(t, err)
+	userMock.ExpectQuery("SELECT DISTINCT table_schema FROM v_catalog.tables ORDER BY table_schema").WillReturnRows(sqlmock.NewRows([]string{"table_schema"}).AddRow("alice_schema"))
+
+	v := &VerticaDatasource{
+		im: datasource.NewInstanceManager(func(_ context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+			return &instanceSettings{Db: shared, Name: settings.Name, userPools: newUserPools(1, func(source accessTokenSource) (*sql.DB, *sql.DB) {
+				return userDB, userInterruptDb
+			})}, nil
+		}),
+	}
+
+	pluginContext := getCredentials(configArgs{URL: "testurl", ForwardOauthIdentity: true})
+	pluginContext.OrgID = 1
+	pluginContext.User = &backend.User{Login: "alice"}
+	ctx := backend.WithPluginContext(context.Background(), pluginContext)
+
+	req := httptest.NewRequest(http.MethodGet, "/schemas", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer alice-token")
+	rw := httptest.NewRecorder()
+	v.handleSchemas(rw, req)
+	require.Equal(t, http.StatusOK, rw.Code, "Catalog of the user should be returned")
+	require.JSONEq(t, `["alice_schema"]`, rw.Body.String(), "Invalid schemas")
+	require.Nil(t, userMock.ExpectationsWereMet(), "Catalog should be browsed in the pool of the user")
+
+	rw = httptest.NewRecorder()
+	v.handleSchemas(rw, httptest.NewRequest(http.MethodGet, "/schemas", nil).WithContext(ctx))
+	require.Equal(t, http.StatusUnauthorized, rw.Code, "Requests without token should be rejected")
+}
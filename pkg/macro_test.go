@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/stretchr/testify/require"
 )
 
@@ -82,6 +84,55 @@ func Test_Macros(t *testing.T) {
 			expectedQuery: "SELECT start_time FROM test_table WHERE end_time >= 1609502400 AND end_time <= 1611576000",
 			expectingErr:  nil,
 		},
+		{
+			name:          "Correct unixEpochFrom Macro defination pass",
+			rawSQL:        "SELECT start_time FROM test_table WHERE end_time > $__unixEpochFrom()",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT start_time FROM test_table WHERE end_time > $__unixEpochFrom()"}),
+			expectedQuery: "SELECT start_time FROM test_table WHERE end_time > 1609502400",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct unixEpochTo Macro defination pass",
+			rawSQL:        "SELECT start_time FROM test_table WHERE end_time < $__unixEpochTo()",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT start_time FROM test_table WHERE end_time < $__unixEpochTo()"}),
+			expectedQuery: "SELECT start_time FROM test_table WHERE end_time < 1611576000",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct unixEpochNanoFilter Macro defination pass",
+			rawSQL:        "SELECT start_time FROM test_table WHERE $__unixEpochNanoFilter(end_time)",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT start_time FROM test_table WHERE $__unixEpochNanoFilter(end_time)"}),
+			expectedQuery: "SELECT start_time FROM test_table WHERE end_time >= 1609502400000000000 AND end_time <= 1611576000000000000",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct unixEpochNanoFrom Macro defination pass",
+			rawSQL:        "SELECT start_time FROM test_table WHERE end_time > $__unixEpochNanoFrom()",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT start_time FROM test_table WHERE end_time > $__unixEpochNanoFrom()"}),
+			expectedQuery: "SELECT start_time FROM test_table WHERE end_time > 1609502400000000000",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct unixEpochNanoTo Macro defination pass",
+			rawSQL:        "SELECT start_time FROM test_table WHERE end_time < $__unixEpochNanoTo()",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT start_time FROM test_table WHERE end_time < $__unixEpochNanoTo()"}),
+			expectedQuery: "SELECT start_time FROM test_table WHERE end_time < 1611576000000000000",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct unixEpochGroup Macro defination pass",
+			rawSQL:        "select $__unixEpochGroup(test_epoch,5m,previous), avg(test_number) as 'test_number' from test_table GROUP BY 1 ORDER BY 1",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__unixEpochGroup(test_epoch,5m,previous), avg(test_number) as 'test_number' from test_table GROUP BY 1 ORDER BY 1"}),
+			expectedQuery: "select floor(test_epoch/300)*300 as time, avg(test_number) as 'test_number' from test_table GROUP BY 1 ORDER BY 1",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Wrong unixEpochGroup Macro defination pass",
+			rawSQL:        "select $__unixEpochGroup(test_epoch), avg(test_number) as 'test_number' from test_table GROUP BY 1 ORDER BY 1",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__unixEpochGroup(test_epoch), avg(test_number) as 'test_number' from test_table GROUP BY 1 ORDER BY 1"}),
+			expectedQuery: "",
+			expectingErr:  fmt.Errorf("macro __unixEpochGroup needs time column and interval and optional fill value"),
+		},
 		{
 			name:          "Correct timeGroup Macro defination pass",
 			rawSQL:        "select $__timeGroup(test_time, '1m'), avg(test_number) as 'test_number', avg(test_key) as 'test_key' from test_table GROUP BY 1 ORDER BY 1",
@@ -89,6 +140,20 @@ func Test_Macros(t *testing.T) {
 			expectedQuery: "select floor(extract(epoch from test_time)/60)*60 as time, avg(test_number) as 'test_number', avg(test_key) as 'test_key' from test_table GROUP BY 1 ORDER BY 1",
 			expectingErr:  nil,
 		},
+		{
+			name:          "Correct timeGroup Macro with numeric fill value pass",
+			rawSQL:        "select $__timeGroup(test_time, '5m', 2.5), avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeGroup(test_time, '5m', 2.5), avg(test_number) from test_table GROUP BY 1 ORDER BY 1"}),
+			expectedQuery: "select floor(extract(epoch from test_time)/300)*300 as time, avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Wrong timeGroup Macro fill value pass",
+			rawSQL:        "select $__timeGroup(test_time, '5m', nothing), avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeGroup(test_time, '5m', nothing), avg(test_number) from test_table GROUP BY 1 ORDER BY 1"}),
+			expectedQuery: "",
+			expectingErr:  fmt.Errorf("error parsing fill value nothing, expected NULL, previous or a number"),
+		},
 		{
 			name:          "Wrong timeGroup Macro defination pass",
 			rawSQL:        "select $__timeGroup(test_time), avg(test_number) as 'test_number', avg(test_key) as 'test_key' from test_table GROUP BY 1 ORDER BY 1",
@@ -96,6 +161,97 @@ func Test_Macros(t *testing.T) {
 			expectedQuery: "",
 			expectingErr:  fmt.Errorf("macro __timeGroup needs time column and interval and optional fill value"),
 		},
+		{
+			name:          "Correct timeSlice Macro defination pass",
+			rawSQL:        "select $__timeSlice(start_time, '5m'), avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeSlice(start_time, '5m'), avg(test_number) from test_table GROUP BY 1 ORDER BY 1"}),
+			expectedQuery: "select TIME_SLICE(start_time, 300, 'SECOND', 'START') as time, avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct timeSlice Macro with END and sub second interval defination pass",
+			rawSQL:        "select $__timeSlice(start_time, 1500ms, 'end') from test_table",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeSlice(start_time, 1500ms, 'end') from test_table"}),
+			expectedQuery: "select TIME_SLICE(start_time, 1500, 'MILLISECOND', 'END') as time from test_table",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct timeSlice Macro in the dashboard timezone defination pass",
+			rawSQL:        "select $__timeSlice(start_time, $__interval) from test_table",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeSlice(start_time, $__interval) from test_table", IntervalMs: 3600000, Timezone: "Asia/Kolkata"}),
+			expectedQuery: "select TIME_SLICE(start_time AT TIME ZONE 'Asia/Kolkata', 3600, 'SECOND', 'START') AT TIME ZONE 'Asia/Kolkata' as time from test_table",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct timeSlice Macro in the browser timezone defination pass",
+			rawSQL:        "select $__timeSlice(start_time, 1h) from test_table",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeSlice(start_time, 1h) from test_table", Timezone: "browser"}),
+			expectedQuery: "select TIME_SLICE(start_time, 3600, 'SECOND', 'START') as time from test_table",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Wrong timeSlice Macro boundary defination pass",
+			rawSQL:        "select $__timeSlice(start_time, 1h, 'MIDDLE') from test_table",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeSlice(start_time, 1h, 'MIDDLE') from test_table"}),
+			expectedQuery: "",
+			expectingErr:  fmt.Errorf("error parsing slice boundary 'MIDDLE', expected START or END"),
+		},
+		{
+			name:          "Correct timeseries Macro defination pass",
+			rawSQL:        "SELECT slice_time AS time, $__tsValue(cpu, 'linear') AS cpu FROM cpu_usage WHERE $__unixEpochFilter(ts) $__timeseries(ts, '30s')",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT slice_time AS time, $__tsValue(cpu, 'linear') AS cpu FROM cpu_usage WHERE $__unixEpochFilter(ts) $__timeseries(ts, '30s')"}),
+			expectedQuery: "SELECT slice_time AS time, TS_FIRST_VALUE(cpu, 'LINEAR') AS cpu FROM cpu_usage WHERE ts >= 1609502400 AND ts <= 1611576000 TIMESERIES slice_time AS '30 seconds' OVER (ORDER BY ts)",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct timeseries Macro with partitions defination pass",
+			rawSQL:        "SELECT slice_time AS time, node, $__tsLastValue(coalesce(cpu, 0)) AS cpu FROM cpu_usage $__timeseries(ts, $__interval, node, host)",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT slice_time AS time, node, $__tsLastValue(coalesce(cpu, 0)) AS cpu FROM cpu_usage $__timeseries(ts, $__interval, node, host)", IntervalMs: 500}),
+			expectedQuery: "SELECT slice_time AS time, node, TS_LAST_VALUE(coalesce(cpu, 0), 'CONST') AS cpu FROM cpu_usage TIMESERIES slice_time AS '500 milliseconds' OVER (PARTITION BY node, host ORDER BY ts)",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Wrong tsValue Macro interpolation defination pass",
+			rawSQL:        "SELECT $__tsValue(cpu, 'cubic') FROM cpu_usage $__timeseries(ts, 1m)",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT $__tsValue(cpu, 'cubic') FROM cpu_usage $__timeseries(ts, 1m)"}),
+			expectedQuery: "",
+			expectingErr:  fmt.Errorf("error parsing interpolation 'cubic', expected linear or const"),
+		},
+		{
+			name:          "Correct interval and maxDataPoints Macro defination pass",
+			rawSQL:        "SELECT $__interval_ms, '$__interval', $__maxDataPoints FROM test_table",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT $__interval_ms, '$__interval', $__maxDataPoints FROM test_table", IntervalMs: 30000, MaxDataPoints: 1000}),
+			expectedQuery: "SELECT 30000, '$__interval', 1000 FROM test_table",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct timeGroup Macro with interval defination pass",
+			rawSQL:        "select $__timeGroup(test_time, $__interval, previous), avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeGroup(test_time, $__interval, previous), avg(test_number) from test_table GROUP BY 1 ORDER BY 1", IntervalMs: 60000}),
+			expectedQuery: "select floor(extract(epoch from test_time)/60)*60 as time, avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct timeGroup Macro with auto interval defination pass",
+			rawSQL:        "select $__timeGroup(test_time, auto), avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeGroup(test_time, auto), avg(test_number) from test_table GROUP BY 1 ORDER BY 1", IntervalMs: 30000, MaxDataPoints: 100000}),
+			expectedQuery: "select floor(extract(epoch from test_time)/30)*30 as time, avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Correct timeGroup Macro with auto interval limited by maxDataPoints defination pass",
+			rawSQL:        "select $__unixEpochGroup(test_epoch, auto), avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__unixEpochGroup(test_epoch, auto), avg(test_number) from test_table GROUP BY 1 ORDER BY 1", IntervalMs: 30000, MaxDataPoints: 1000}),
+			expectedQuery: "select floor(test_epoch/2073.6)*2073.6 as time, avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			expectingErr:  nil,
+		},
+		{
+			name:          "Wrong timeGroup Macro with auto interval defination pass",
+			rawSQL:        "select $__timeGroup(test_time, auto), avg(test_number) from test_table GROUP BY 1 ORDER BY 1",
+			dataQuery:     getDataQuery(queryModel{RawSQL: "select $__timeGroup(test_time, auto), avg(test_number) from test_table GROUP BY 1 ORDER BY 1"}),
+			expectedQuery: "",
+			expectingErr:  fmt.Errorf("auto interval needs the interval or max data points of the query"),
+		},
 	}
 
 	//v := VerticaDatasource{}
@@ -106,7 +262,7 @@ func Test_Macros(t *testing.T) {
 
 		t.Run(tc.name, func(tt *testing.T) {
 
-			querySQL, err := sanitizeAndInterpolateMacros(tc.rawSQL, tc.dataQuery)
+			querySQL, _, err := sanitizeAndInterpolateMacros(tc.rawSQL, tc.dataQuery, &instanceSettings{})
 
 			if tc.expectingErr != nil {
 				require.NotNil(t, err, "Should set error if failed")
@@ -120,3 +276,165 @@ func Test_Macros(t *testing.T) {
 	}
 
 }
+
+func Test_FormatInterval(t *testing.T) {
+
+	fmt.Println("Interval Formatting Tests")
+
+	tests := []struct {
+		interval time.Duration
+		expected string
+	}{
+		{interval: 500 * time.Millisecond, expected: "500ms"},
+		{interval: 30 * time.Second, expected: "30s"},
+		{interval: 90 * time.Second, expected: "1m"},
+		{interval: 2 * time.Hour, expected: "2h"},
+		{interval: 48 * time.Hour, expected: "2d"},
+	}
+
+	for _, tc := range tests {
+		require.Equal(t, tc.expected, formatInterval(tc.interval), "Invalid formatted interval")
+	}
+}
+
+func Test_Macros_Timezone(t *testing.T) {
+
+	fmt.Println("Macros Time Zone Tests")
+
+	newYork, err := time.LoadLocation("America/New_York")
+	require.Nil(t, err, "The time zone database should be available")
+
+	// Time ranges of an hour around the DST transitions of America/New_York in 2021,
+	// both bounds of the fall back range are 01:30 in local time.
+	springForward := backend.TimeRange{From: time.Date(2021, 3, 14, 6, 30, 0, 0, time.UTC), To: time.Date(2021, 3, 14, 7, 30, 0, 0, time.UTC)}
+	fallBack := backend.TimeRange{From: time.Date(2021, 11, 7, 5, 30, 0, 0, time.UTC), To: time.Date(2021, 11, 7, 6, 30, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name             string
+		rawSQL           string
+		timeRange        backend.TimeRange
+		databaseTimezone *time.Location
+		expectedQuery    string
+		expectingErr     error
+	}{
+		{
+			name:          "timeFilter without time zone",
+			rawSQL:        "SELECT * FROM test_table WHERE $__timeFilter(test_time)",
+			timeRange:     springForward,
+			expectedQuery: "SELECT * FROM test_table WHERE test_time BETWEEN '2021-03-14T06:30:00Z' AND '2021-03-14T07:30:00Z'",
+		},
+		{
+			name:          "timeFilter with time zone across spring forward",
+			rawSQL:        "SELECT * FROM test_table WHERE $__timeFilter(test_time, 'America/New_York')",
+			timeRange:     springForward,
+			expectedQuery: "SELECT * FROM test_table WHERE test_time BETWEEN TIMESTAMPTZ '2021-03-14 06:30:00+00' AT TIME ZONE 'America/New_York' AND TIMESTAMPTZ '2021-03-14 07:30:00+00' AT TIME ZONE 'America/New_York'",
+		},
+		{
+			name:          "timeFilter with time zone across fall back",
+			rawSQL:        "SELECT * FROM test_table WHERE $__timeFilter(test_time, 'America/New_York')",
+			timeRange:     fallBack,
+			expectedQuery: "SELECT * FROM test_table WHERE test_time BETWEEN TIMESTAMPTZ '2021-11-07 05:30:00+00' AT TIME ZONE 'America/New_York' AND TIMESTAMPTZ '2021-11-07 06:30:00+00' AT TIME ZONE 'America/New_York'",
+		},
+		{
+			name:             "timeFilter with database time zone",
+			rawSQL:           "SELECT * FROM test_table WHERE $__timeFilter(test_time)",
+			timeRange:        springForward,
+			databaseTimezone: newYork,
+			expectedQuery:    "SELECT * FROM test_table WHERE test_time BETWEEN TIMESTAMPTZ '2021-03-14 06:30:00+00' AT TIME ZONE 'America/New_York' AND TIMESTAMPTZ '2021-03-14 07:30:00+00' AT TIME ZONE 'America/New_York'",
+		},
+		{
+			name:             "timeFilter time zone overriding database time zone",
+			rawSQL:           "SELECT * FROM test_table WHERE $__timeFilter(test_time, 'Asia/Kolkata')",
+			timeRange:        springForward,
+			databaseTimezone: newYork,
+			expectedQuery:    "SELECT * FROM test_table WHERE test_time BETWEEN TIMESTAMPTZ '2021-03-14 06:30:00+00' AT TIME ZONE 'Asia/Kolkata' AND TIMESTAMPTZ '2021-03-14 07:30:00+00' AT TIME ZONE 'Asia/Kolkata'",
+		},
+		{
+			name:          "timeFrom and timeTo with time zone",
+			rawSQL:        "SELECT * FROM test_table WHERE test_time >= $__timeFrom('America/New_York') AND test_time < $__timeTo('America/New_York')",
+			timeRange:     fallBack,
+			expectedQuery: "SELECT * FROM test_table WHERE test_time >= TIMESTAMPTZ '2021-11-07 05:30:00+00' AT TIME ZONE 'America/New_York' AND test_time < TIMESTAMPTZ '2021-11-07 06:30:00+00' AT TIME ZONE 'America/New_York'",
+		},
+		{
+			name:             "timeFrom with database time zone",
+			rawSQL:           "SELECT * FROM test_table WHERE test_time >= $__timeFrom()",
+			timeRange:        fallBack,
+			databaseTimezone: newYork,
+			expectedQuery:    "SELECT * FROM test_table WHERE test_time >= TIMESTAMPTZ '2021-11-07 05:30:00+00' AT TIME ZONE 'America/New_York'",
+		},
+		{
+			name:         "timeFilter with unknown time zone",
+			rawSQL:       "SELECT * FROM test_table WHERE $__timeFilter(test_time, 'Mars/Olympus')",
+			timeRange:    springForward,
+			expectingErr: fmt.Errorf("unknown time zone 'Mars/Olympus' at line 1 col 32"),
+		},
+		{
+			name:         "timeTo with too many arguments",
+			rawSQL:       "SELECT * FROM test_table WHERE test_time < $__timeTo('UTC', 'UTC')",
+			timeRange:    springForward,
+			expectingErr: fmt.Errorf("macro __timeTo takes an optional time zone argument at line 1 col 44"),
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			dataQuery := getDataQuery(queryModel{RawSQL: tc.rawSQL})
+			dataQuery.TimeRange = tc.timeRange
+
+			querySQL, _, err := sanitizeAndInterpolateMacros(tc.rawSQL, dataQuery, &instanceSettings{databaseTimezone: tc.databaseTimezone})
+
+			if tc.expectingErr != nil {
+				require.EqualError(tt, err, tc.expectingErr.Error(), "Invalid error")
+				require.Equal(tt, "", querySQL, "Query sanitization should result null if wrong query has been passed")
+			} else {
+				require.Nil(tt, err, "Correct query shouldn't throw any errors")
+				require.Equal(tt, tc.expectedQuery, querySQL, "After sanitization the Raw Query SQL should be same")
+			}
+		})
+	}
+}
+
+func Test_Macros_FillMode(t *testing.T) {
+
+	fmt.Println("Macros Fill Mode Tests")
+
+	tests := []struct {
+		name                string
+		fillArgument        string
+		expectedFillMissing *data.FillMissing
+	}{
+		{
+			name:                "Lower case NULL fill mode",
+			fillArgument:        "null",
+			expectedFillMissing: &data.FillMissing{Mode: data.FillModeNull},
+		},
+		{
+			name:                "Quoted previous fill mode",
+			fillArgument:        "'previous'",
+			expectedFillMissing: &data.FillMissing{Mode: data.FillModePrevious},
+		},
+		{
+			name:                "Upper case previous fill mode",
+			fillArgument:        "PREVIOUS",
+			expectedFillMissing: &data.FillMissing{Mode: data.FillModePrevious},
+		},
+		{
+			name:                "Quoted numeric fill value",
+			fillArgument:        "'0'",
+			expectedFillMissing: &data.FillMissing{Mode: data.FillModeValue, Value: 0},
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			rawSQL := "select $__timeGroup(test_time, '5m', " + tc.fillArgument + ") from test_table"
+			_, macroCtx, err := sanitizeAndInterpolateMacros(rawSQL, getDataQuery(queryModel{RawSQL: rawSQL}), &instanceSettings{})
+			require.Nil(tt, err, "Fill mode of existing dashboards shouldn't throw any errors")
+			require.Equal(tt, tc.expectedFillMissing, macroCtx.fillMissing, "Fill mode should be set from the fill argument")
+		})
+	}
+}
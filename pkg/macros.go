@@ -33,26 +33,163 @@ package main
 // THE SOFTWARE.
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
-// Regex to find the macros in the raw SQL query.
-const macroPattern = `\$(__[_a-zA-Z0-9]+)`
+// macroContext carries the state of a single query while its macros are
+// evaluated, including the side effects of the macros which are needed later
+// on to build the response frame.
+type macroContext struct {
+	// timeRange is the time range of the query.
+	timeRange backend.TimeRange
 
-var fillMode data.FillMode = data.FillModeNull
+	// timeGroup is set when the query uses a time group macro.
+	timeGroup bool
+
+	// interval is the bucket size of the time group macros.
+	interval time.Duration
+
+	// queryInterval is the interval of the panel, or of the alert rule, of the query.
+	queryInterval time.Duration
+
+	// maxDataPoints is the maximum number of points of the query.
+	maxDataPoints int64
+
+	// timezone is the time zone of the dashboard, nil when it is UTC or unknown.
+	timezone *time.Location
+
+	// databaseTimezone is the time zone of the TIMESTAMP columns of the datasource,
+	// used by the time filter macros, nil when it is not configured.
+	databaseTimezone *time.Location
+
+	// customMacros are the macros defined in the settings of the datasource.
+	customMacros map[string]customMacro
+
+	// fillMissing is set by the optional fill argument of the time group macros
+	// and is nil when missing buckets should not be filled.
+	fillMissing *data.FillMissing
+}
+
+// Function to create the macro evaluation context of a query. The intervalMs and
+// maxDataPoints sent by the frontend take precedence over the ones of the request.
+func newMacroContext(query backend.DataQuery, instance *instanceSettings) *macroContext {
+	var queryArgs queryModel
+	json.Unmarshal(query.JSON, &queryArgs)
+
+	macroCtx := &macroContext{
+		timeRange:     query.TimeRange,
+		queryInterval: query.Interval,
+		maxDataPoints: query.MaxDataPoints,
+	}
+	if instance != nil {
+		macroCtx.databaseTimezone = instance.databaseTimezone
+		macroCtx.customMacros = instance.customMacros
+	}
+	if queryArgs.IntervalMs > 0 {
+		macroCtx.queryInterval = time.Duration(queryArgs.IntervalMs) * time.Millisecond
+	}
+	if queryArgs.MaxDataPoints > 0 {
+		macroCtx.maxDataPoints = queryArgs.MaxDataPoints
+	}
+	// The browser time zone is not known by the backend, the UTC one needs no conversion.
+	if tz := queryArgs.Timezone; tz != "" && tz != "browser" && !strings.EqualFold(tz, "utc") {
+		if location, err := time.LoadLocation(tz); err == nil {
+			macroCtx.timezone = location
+		} else {
+			log.DefaultLogger.Warn("Unknown dashboard time zone, using UTC", "timezone", tz, "err", err)
+		}
+	}
+	return macroCtx
+}
+
+// Function to get the bucket size of the time group macros called with the auto
+// interval. It is the interval of the query, widened if needed so that the time
+// range is covered by at most maxDataPoints buckets.
+func (macroCtx *macroContext) autoInterval() (time.Duration, error) {
+	interval := macroCtx.queryInterval
+	if macroCtx.maxDataPoints > 0 {
+		minInterval := macroCtx.timeRange.To.Sub(macroCtx.timeRange.From) / time.Duration(macroCtx.maxDataPoints)
+		if minInterval > interval {
+			interval = minInterval
+		}
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("auto interval needs the interval or max data points of the query")
+	}
+	return interval, nil
+}
+
+// Function to parse the interval argument of the time group macros, which is either
+// a duration or auto.
+func (macroCtx *macroContext) parseInterval(arg string) (time.Duration, error) {
+	arg = strings.Trim(arg, `'`)
+	if arg == "auto" {
+		return macroCtx.autoInterval()
+	}
+	interval, err := ParseDuration(arg)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("error parsing interval %v", arg)
+	}
+	return interval, nil
+}
+
+// Layout of the TIMESTAMPTZ literals of the time filter macros, Vertica stores the
+// timestamps with a microsecond precision.
+const timestampLiteralLayout = "2006-01-02 15:04:05.999999-07"
+
+// Function to get the time zone of the time filter macros. The optional argument
+// of the macro takes precedence over the time zone of the datasource.
+func (macroCtx *macroContext) filterTimezone(args []string) (*time.Location, error) {
+	if len(args) == 0 {
+		return macroCtx.databaseTimezone, nil
+	}
+	tz := strings.Trim(args[0], `'`)
+	location, err := time.LoadLocation(tz)
+	if err != nil || tz == "" {
+		return nil, fmt.Errorf("unknown time zone %v", args[0])
+	}
+	return location, nil
+}
+
+// Function to format a bound of the time range for the time filter macros. Without
+// a time zone the bound is an RFC3339 timestamp. With one the UTC bound is converted
+// by the database to the wall clock time of the zone, to be compared with TIMESTAMP
+// columns holding local times, which keeps the DST offsets right.
+func timeBoundLiteral(t time.Time, location *time.Location) string {
+	if location == nil {
+		return fmt.Sprintf("'%s'", time.Unix(0, t.UnixNano()).Format(time.RFC3339Nano))
+	}
+	return fmt.Sprintf("TIMESTAMPTZ '%s' AT TIME ZONE %s", t.UTC().Format(timestampLiteralLayout), quoteLiteral(location.String()))
+}
+
+// Alias of the slices of the TIMESERIES clause generated by the $__timeseries macro.
+const timeSeriesSliceAlias = "slice_time"
+
+// Maximum number of levels of macros nested in the arguments of other macros or in
+// the SQL of custom macros.
+const maxMacroNestingDepth = 5
 
 // Slice of macros which requires more than 1 argument.
-var macrosWithMultipleArgument = []string{"__timeGroup"}
+var macrosWithMultipleArgument = []string{"__timeFilter", "__timeFrom", "__timeTo", "__timeGroup", "__unixEpochGroup", "__timeSlice", "__timeseries", "__tsValue", "__tsLastValue"}
+
+// Slice of the built-in macros, which cannot be redefined by the custom macros.
+var builtinMacros = []string{"__time", "__timeFilter", "__timeFrom", "__timeTo", "__expandMultiString",
+	"__timeGroup", "__unixEpochGroup", "__timeSlice", "__timeseries", "__tsValue", "__tsLastValue",
+	"__unixEpochFilter", "__unixEpochFrom", "__unixEpochTo", "__unixEpochNanoFilter", "__unixEpochNanoFrom",
+	"__unixEpochNanoTo", "__interval", "__interval_ms", "__maxDataPoints"}
 
 // Function to evaluate the macro function and convert it into an appropriate query syntex.
-func evaluateMacro(name string, args []string, timeRange backend.TimeRange) (string, error) {
+func evaluateMacro(name string, args []string, macroCtx *macroContext) (string, error) {
 
 	log.DefaultLogger.Debug("Inside macros.evaluateMacro Function")
 
@@ -66,26 +203,36 @@ func evaluateMacro(name string, args []string, timeRange backend.TimeRange) (str
 		if len(args) == 0 {
 			return "", fmt.Errorf("missing time column argument for macro %v", name)
 		}
-		return fmt.Sprintf("%s BETWEEN '%s' AND '%s'",
+		if len(args) > 2 {
+			return "", fmt.Errorf("macro %v takes a time column and an optional time zone", name)
+		}
+		location, err := macroCtx.filterTimezone(args[1:])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s BETWEEN %s AND %s",
 				args[0],
-				time.Unix(0, timeRange.From.UnixNano()).Format(time.RFC3339Nano),
-				time.Unix(0, timeRange.To.UnixNano()).Format(time.RFC3339Nano)),
+				timeBoundLiteral(macroCtx.timeRange.From, location),
+				timeBoundLiteral(macroCtx.timeRange.To, location)),
 			nil
 	case "__timeFrom":
-		log.DefaultLogger.Info("QueryData", "timeR", timeRange.From)
-		if len(args) != 0 {
-			return "", fmt.Errorf("macro %v should have no arguments", name)
+		if len(args) > 1 {
+			return "", fmt.Errorf("macro %v takes an optional time zone argument", name)
 		}
-		return fmt.Sprintf("'%s'",
-				time.Unix(0, timeRange.From.UnixNano()).Format(time.RFC3339Nano)),
-			nil
+		location, err := macroCtx.filterTimezone(args)
+		if err != nil {
+			return "", err
+		}
+		return timeBoundLiteral(macroCtx.timeRange.From, location), nil
 	case "__timeTo":
-		if len(args) != 0 {
-			return "", fmt.Errorf("macro %v should have no arguments", name)
+		if len(args) > 1 {
+			return "", fmt.Errorf("macro %v takes an optional time zone argument", name)
 		}
-		return fmt.Sprintf("'%s'",
-				time.Unix(0, timeRange.To.UnixNano()).Format(time.RFC3339Nano)),
-			nil
+		location, err := macroCtx.filterTimezone(args)
+		if err != nil {
+			return "", err
+		}
+		return timeBoundLiteral(macroCtx.timeRange.To, location), nil
 	case "__expandMultiString":
 		if len(args) == 0 {
 			return "", fmt.Errorf("missing selector argument for macro: %v", name)
@@ -107,124 +254,245 @@ func evaluateMacro(name string, args []string, timeRange backend.TimeRange) (str
 		if len(args) < 2 {
 			return "", fmt.Errorf("macro %v needs time column and interval and optional fill value", name)
 		}
-		interval, err := ParseDuration(strings.Trim(args[1], `'`))
+		interval, err := macroCtx.parseInterval(args[1])
 		if err != nil {
-			return "", fmt.Errorf("error parsing interval %v", args[1])
+			return "", err
 		}
+		macroCtx.timeGroup, macroCtx.interval = true, interval
 		if len(args) == 3 {
-			if args[2] == "previous" {
-				fillMode = data.FillModePrevious
-			} else if args[2] == "NULL" {
-				fillMode = data.FillModeNull
-			} else if args[2] == "0" {
-				fillMode = data.FillModeValue
+			if err := macroCtx.setFillMode(args[2]); err != nil {
+				return "", err
 			}
 		}
 		return fmt.Sprintf("floor(extract(epoch from %s)/%v)*%v as time", args[0], interval.Seconds(), interval.Seconds()), nil
+	case "__unixEpochGroup":
+		if len(args) < 2 {
+			return "", fmt.Errorf("macro %v needs time column and interval and optional fill value", name)
+		}
+		interval, err := macroCtx.parseInterval(args[1])
+		if err != nil {
+			return "", err
+		}
+		macroCtx.timeGroup, macroCtx.interval = true, interval
+		if len(args) == 3 {
+			if err := macroCtx.setFillMode(args[2]); err != nil {
+				return "", err
+			}
+		}
+		return fmt.Sprintf("floor(%s/%v)*%v as time", args[0], interval.Seconds(), interval.Seconds()), nil
+	case "__timeSlice":
+		if len(args) < 2 || len(args) > 3 {
+			return "", fmt.Errorf("macro %v needs time column, interval and optional START or END", name)
+		}
+		interval, err := macroCtx.parseInterval(args[1])
+		if err != nil {
+			return "", err
+		}
+		macroCtx.interval = interval
+		// TIME_SLICE only accepts an integer slice length.
+		length, unit := int64(interval/time.Second), "SECOND"
+		if interval%time.Second != 0 {
+			length, unit = interval.Milliseconds(), "MILLISECOND"
+		}
+		if length <= 0 {
+			return "", fmt.Errorf("interval %v of macro %v should be at least 1ms", args[1], name)
+		}
+		boundary := "START"
+		if len(args) == 3 {
+			boundary = strings.ToUpper(strings.Trim(args[2], `'`))
+			if boundary != "START" && boundary != "END" {
+				return "", fmt.Errorf("error parsing slice boundary %v, expected START or END", args[2])
+			}
+		}
+		if macroCtx.timezone != nil {
+			// Slicing the local time of the dashboard aligns the slices on its days and hours.
+			tz := quoteLiteral(macroCtx.timezone.String())
+			return fmt.Sprintf("TIME_SLICE(%s AT TIME ZONE %s, %d, '%s', '%s') AT TIME ZONE %s as time", args[0], tz, length, unit, boundary, tz), nil
+		}
+		return fmt.Sprintf("TIME_SLICE(%s, %d, '%s', '%s') as time", args[0], length, unit, boundary), nil
+	case "__timeseries":
+		if len(args) < 2 {
+			return "", fmt.Errorf("macro %v needs time column, interval and optional partition columns", name)
+		}
+		interval, err := macroCtx.parseInterval(args[1])
+		if err != nil {
+			return "", err
+		}
+		macroCtx.interval = interval
+		length := fmt.Sprintf("%d seconds", interval/time.Second)
+		if interval%time.Second != 0 {
+			length = fmt.Sprintf("%d milliseconds", interval.Milliseconds())
+		}
+		over := "ORDER BY " + args[0]
+		if len(args) > 2 {
+			over = "PARTITION BY " + strings.Join(args[2:], ", ") + " " + over
+		}
+		return fmt.Sprintf("TIMESERIES %s AS '%s' OVER (%s)", timeSeriesSliceAlias, length, over), nil
+	case "__tsValue", "__tsLastValue":
+		if len(args) < 1 || len(args) > 2 {
+			return "", fmt.Errorf("macro %v needs an expression and optional interpolation, linear or const", name)
+		}
+		function := "TS_FIRST_VALUE"
+		if name == "__tsLastValue" {
+			function = "TS_LAST_VALUE"
+		}
+		interpolation := "CONST"
+		if len(args) == 2 {
+			interpolation = strings.ToUpper(strings.Trim(args[1], `'`))
+			if interpolation != "CONST" && interpolation != "LINEAR" {
+				return "", fmt.Errorf("error parsing interpolation %v, expected linear or const", args[1])
+			}
+		}
+		return fmt.Sprintf("%s(%s, '%s')", function, args[0], interpolation), nil
 	case "__unixEpochFilter":
 		if len(args) == 0 {
 			return "", fmt.Errorf("missing time column argument for macro %v", name)
 		}
-		return fmt.Sprintf("%s >= %d AND %s <= %d", args[0], timeRange.From.Unix(), args[0], timeRange.To.Unix()), nil
+		return fmt.Sprintf("%s >= %d AND %s <= %d", args[0], macroCtx.timeRange.From.Unix(), args[0], macroCtx.timeRange.To.Unix()), nil
+	case "__unixEpochFrom":
+		if len(args) != 0 {
+			return "", fmt.Errorf("macro %v should have no arguments", name)
+		}
+		return fmt.Sprintf("%d", macroCtx.timeRange.From.Unix()), nil
+	case "__unixEpochTo":
+		if len(args) != 0 {
+			return "", fmt.Errorf("macro %v should have no arguments", name)
+		}
+		return fmt.Sprintf("%d", macroCtx.timeRange.To.Unix()), nil
+	case "__unixEpochNanoFilter":
+		if len(args) == 0 {
+			return "", fmt.Errorf("missing time column argument for macro %v", name)
+		}
+		return fmt.Sprintf("%s >= %d AND %s <= %d", args[0], macroCtx.timeRange.From.UnixNano(), args[0], macroCtx.timeRange.To.UnixNano()), nil
+	case "__unixEpochNanoFrom":
+		if len(args) != 0 {
+			return "", fmt.Errorf("macro %v should have no arguments", name)
+		}
+		return fmt.Sprintf("%d", macroCtx.timeRange.From.UnixNano()), nil
+	case "__unixEpochNanoTo":
+		if len(args) != 0 {
+			return "", fmt.Errorf("macro %v should have no arguments", name)
+		}
+		return fmt.Sprintf("%d", macroCtx.timeRange.To.UnixNano()), nil
+	case "__interval":
+		if len(args) != 0 {
+			return "", fmt.Errorf("macro %v should have no arguments", name)
+		}
+		return formatInterval(macroCtx.queryInterval), nil
+	case "__interval_ms":
+		if len(args) != 0 {
+			return "", fmt.Errorf("macro %v should have no arguments", name)
+		}
+		return strconv.FormatInt(macroCtx.queryInterval.Milliseconds(), 10), nil
+	case "__maxDataPoints":
+		if len(args) != 0 {
+			return "", fmt.Errorf("macro %v should have no arguments", name)
+		}
+		return strconv.FormatInt(macroCtx.maxDataPoints, 10), nil
 	default:
+		// The custom macros of the datasource are resolved after the built-in ones.
+		if macro, ok := macroCtx.customMacros[name]; ok {
+			return macro.expand(args)
+		}
 		return "", fmt.Errorf("undefined macro: $__%v", name)
 	}
 }
 
-func replaceAllStringSubmatchFunc(re *regexp.Regexp, rawSQL string, repl func([]string) (string, error)) (string, error) {
-
-	log.DefaultLogger.Debug("Inside macros.replaceAllStringSubmatchFunc Function")
-
-	result := ""
-	lastIndex := 0
-	argLength := 0
-
-	// Find macros and their arguments if any.
-	for _, matchIndex := range re.FindAllSubmatchIndex([]byte(rawSQL), -1) {
-		groups := []string{}
-		// matchIndex is slice of integers that contains index of the matched groups.
-		// Example: [1 3 2 5], Here "1" is the starting index of group1 and "3" is
-		// ending index of group1 and so on.
-		// Iterate matched groups in the rawSQL.
-		for i := 0; i < len(matchIndex); i += 2 {
-			groups = append(groups, rawSQL[matchIndex[i]:matchIndex[i+1]])
-		}
-
-		parenthesisCount := 1
-		arg := []byte{}
-		startArgIndex := matchIndex[len(matchIndex)-1] + 1
-		// Check if macro has any argument or not.
-		if len(rawSQL) > startArgIndex+2 {
-			// Find the macro argument.
-			for {
-				if string(rawSQL[startArgIndex]) == ")" && parenthesisCount == 0 {
-					break
-				}
-				arg = append(arg, rawSQL[startArgIndex])
-				startArgIndex++
-				if string(rawSQL[startArgIndex]) == "(" {
-					parenthesisCount++
-				} else if string(rawSQL[startArgIndex]) == ")" {
-					parenthesisCount--
-				}
-			}
-		}
-		argLength = len(arg)
-		// Add macro argument in the groups.
-		groups = append(groups, string(arg))
-
-		replacement, err := repl(groups)
-
-		if err != nil {
-			log.DefaultLogger.Error("Error while replacing the string submatches: " + err.Error())
-			return "", err
-		}
-
-		// Replace the macro with the replacement.
-		result += rawSQL[lastIndex:matchIndex[0]] + replacement
-		// Append the remaining query after the argument.
-		lastIndex = matchIndex[1] + argLength + 2
+// Function to set the fill mode from the optional fill argument of the time group macros.
+// The argument is either NULL, previous or a numeric value, quoted or not, and the
+// keywords are matched whatever their case.
+func (macroCtx *macroContext) setFillMode(arg string) error {
+	mode := strings.Trim(arg, `'`)
+	if strings.EqualFold(mode, "previous") {
+		macroCtx.fillMissing = &data.FillMissing{Mode: data.FillModePrevious}
+	} else if strings.EqualFold(mode, "NULL") {
+		macroCtx.fillMissing = &data.FillMissing{Mode: data.FillModeNull}
+	} else if value, err := strconv.ParseFloat(mode, 64); err == nil {
+		macroCtx.fillMissing = &data.FillMissing{Mode: data.FillModeValue, Value: value}
+	} else {
+		return fmt.Errorf("error parsing fill value %v, expected NULL, previous or a number", arg)
 	}
-
-	return result + rawSQL[lastIndex:], nil
+	return nil
 }
 
 // Function to santize and interpolate macro function in the raw sql query.
-func sanitizeAndInterpolateMacros(rawSQL string, query backend.DataQuery) (string, error) {
+// It returns the macro context holding the per query side effects of the macros.
+func sanitizeAndInterpolateMacros(rawSQL string, query backend.DataQuery, instance *instanceSettings) (string, *macroContext, error) {
 
 	log.DefaultLogger.Debug("Inside macros.sanitizeAndInterpolateMacros Function")
 
-	regex, err := regexp.Compile(macroPattern)
+	macroCtx := newMacroContext(query, instance)
+	sql, err := macroCtx.expandMacros(rawSQL, func(offset int) int { return offset }, 0)
+	if err != nil {
+		err = positionedError(rawSQL, err)
+		log.DefaultLogger.Error("Error while evaluating the macros: " + err.Error())
+		return "", macroCtx, err
+	}
+	return sql, macroCtx, nil
+}
 
+// Function to replace the macro calls of the SQL with their evaluation. The macros
+// nested in the arguments of a macro are expanded before it, and the ones of the
+// SQL of a custom macro after it. The origin function maps the offsets of the SQL
+// to the ones of the query of the user, so that the errors are located in it.
+func (macroCtx *macroContext) expandMacros(sql string, origin func(int) int, depth int) (string, error) {
+	tokens, err := tokenizeMacros(sql)
 	if err != nil {
-		log.DefaultLogger.Error("Error while compiling regex for macro: " + err.Error())
-		return rawSQL, err
+		return "", relocateError(err, origin)
 	}
 
-	sql, err := replaceAllStringSubmatchFunc(regex, rawSQL, func(groups []string) (string, error) {
+	var result strings.Builder
+	lastIndex := 0
+	for _, token := range tokens {
+		if depth == maxMacroNestingDepth {
+			return "", newMacroError(origin(token.start), "macros nested more than %d levels deep", maxMacroNestingDepth)
+		}
 
 		var args []string
-
-		if len(groups) > 2 && len(groups[2]) > 0 {
-			if contains(macrosWithMultipleArgument, groups[1]) {
-				args = strings.Split(groups[2], ",")
+		if strings.TrimSpace(token.args) != "" {
+			argsStart := token.start + len(token.name) + 2
+			var starts []int
+			if _, custom := macroCtx.customMacros[token.name]; custom || contains(macrosWithMultipleArgument, token.name) {
+				if args, starts, err = splitArguments(token.args); err != nil {
+					return "", relocateError(err, func(offset int) int { return origin(argsStart + offset) })
+				}
 			} else {
-				args = append(args, groups[2])
+				args, starts = []string{token.args}, []int{0}
 			}
 			for i, arg := range args {
-				args[i] = strings.Trim(arg, " ")
+				argStart := argsStart + starts[i] + len(arg) - len(strings.TrimLeftFunc(arg, unicode.IsSpace))
+				arg = strings.TrimSpace(arg)
+				if args[i], err = macroCtx.expandMacros(arg, func(offset int) int { return origin(argStart + offset) }, depth+1); err != nil {
+					return "", err
+				}
 			}
 		}
 
-		/* TODO: check for multiple Queries */
-		res, err := evaluateMacro(groups[1], args, query.TimeRange)
-
+		res, err := evaluateMacro(token.name, args, macroCtx)
 		if err != nil {
-			return "", err
+			return "", newMacroError(origin(token.start), "%v", err)
+		}
+		if _, custom := macroCtx.customMacros[token.name]; custom {
+			// The errors of the SQL of the custom macro are located at its call.
+			callStart := origin(token.start)
+			if res, err = macroCtx.expandMacros(res, func(int) int { return callStart }, depth+1); err != nil {
+				return "", err
+			}
 		}
 
-		return res, err
-	})
+		result.WriteString(sql[lastIndex:token.start])
+		result.WriteString(res)
+		lastIndex = token.end
+	}
+	result.WriteString(sql[lastIndex:])
+	return result.String(), nil
+}
 
-	return sql, err
+// Function to map the offset of a macro error of an expanded SQL to the query of the user.
+func relocateError(err error, origin func(int) int) error {
+	var macroErr *macroError
+	if !errors.As(err, &macroErr) {
+		return err
+	}
+	return &macroError{offset: origin(macroErr.offset), msg: macroErr.msg}
 }
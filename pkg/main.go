@@ -33,6 +33,9 @@ package main
 // THE SOFTWARE.
 
 import (
+	// The time zone database is embedded, as it may be missing where Grafana runs.
+	_ "time/tzdata"
+
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
@@ -0,0 +1,123 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Version of the multi frame time series type produced by the time_series_multi format.
+var multiFrameTypeVersion = data.FrameTypeVersion{0, 1}
+
+// series holds the labels of a series and the rows of the long frame belonging to it.
+type series struct {
+	labels data.Labels
+	rows   []int
+}
+
+// Function to split a time series frame into one frame per series, as per the multi
+// frame time series type, so that every series can be alerted on independently. The
+// string fields give the labels of the series, and every numeric or boolean field
+// of a series gets its own frame with the time field.
+func toMultiFrames(frame *data.Frame) []*data.Frame {
+	meta := &data.FrameMeta{Type: data.FrameTypeTimeSeriesMulti, TypeVersion: multiFrameTypeVersion}
+	if frame.Meta != nil {
+		meta.ExecutedQueryString = frame.Meta.ExecutedQueryString
+	}
+
+	timeIndices := frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+	if len(timeIndices) == 0 || frame.Rows() == 0 {
+		return []*data.Frame{data.NewFrame(frame.Name).SetMeta(meta)}
+	}
+	timeField := frame.Fields[timeIndices[0]]
+
+	var labelFields, valueFields []*data.Field
+	for idx, field := range frame.Fields {
+		if idx == timeIndices[0] {
+			continue
+		}
+		switch fieldType := field.Type(); {
+		case fieldType == data.FieldTypeString || fieldType == data.FieldTypeNullableString:
+			labelFields = append(labelFields, field)
+		case fieldType.Numeric() || fieldType == data.FieldTypeBool || fieldType == data.FieldTypeNullableBool:
+			valueFields = append(valueFields, field)
+		}
+	}
+
+	// Grouping the rows by their labels, keeping the order in which the series appear.
+	var seriesList []*series
+	seriesByKey := map[string]*series{}
+	for row := 0; row < frame.Rows(); row++ {
+		if _, ok := timeField.ConcreteAt(row); !ok {
+			continue
+		}
+		labels := data.Labels{}
+		keyParts := make([]string, len(labelFields))
+		for i, field := range labelFields {
+			if value, ok := field.ConcreteAt(row); ok {
+				labels[field.Name] = value.(string)
+				keyParts[i] = "=" + value.(string)
+			}
+		}
+		key := strings.Join(keyParts, "\x00")
+		s, ok := seriesByKey[key]
+		if !ok {
+			s = &series{labels: labels}
+			seriesByKey[key] = s
+			seriesList = append(seriesList, s)
+		}
+		s.rows = append(s.rows, row)
+	}
+
+	var frames []*data.Frame
+	for _, s := range seriesList {
+		for _, valueField := range valueFields {
+			times := data.NewFieldFromFieldType(timeField.Type(), 0)
+			times.Name = timeField.Name
+			values := data.NewFieldFromFieldType(valueField.Type(), 0)
+			values.Name, values.Labels, values.Config = valueField.Name, s.labels, valueField.Config
+			for _, row := range s.rows {
+				times.Append(timeField.CopyAt(row))
+				values.Append(valueField.CopyAt(row))
+			}
+			seriesMeta := *meta
+			frames = append(frames, data.NewFrame(valueField.Name, times, values).SetMeta(&seriesMeta))
+		}
+	}
+	if len(frames) == 0 {
+		return []*data.Frame{data.NewFrame(frame.Name).SetMeta(meta)}
+	}
+	return frames
+}
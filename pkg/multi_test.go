@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Query_TimeSeriesMulti(t *testing.T) {
+
+	fmt.Println("Query with Multi Frame Time Series Format Tests")
+
+	db, mock, err := sqlmock.New()
+	require.Nil(t, err)
+	defer db.Close()
+
+	t1 := time.Date(2021, 01, 05, 12, 00, 00, 00, time.UTC)
+	t2 := t1.Add(time.Minute)
+	mockRows := mock.NewRowsWithColumnDefinition(
+		mock.NewColumn("time").OfType("TIMESTAMP", t1),
+		mock.NewColumn("host").OfType("VARCHAR", ""),
+		mock.NewColumn("cpu").OfType("FLOAT", 0.0),
+		mock.NewColumn("sessions").OfType("INT", 0),
+	).
+		AddRow(t1, "node1", 10.5, 3).
+		AddRow(t1, "node2", 20.5, 4).
+		AddRow(t2, "node1", 11.5, 5).
+		AddRow(nil, "node2", 0.0, 0).
+		AddRow(t2, nil, 30.5, 6)
+	mock.ExpectQuery("SELECT time, host, cpu, sessions FROM test_table").WillReturnRows(mockRows)
+
+	v := &VerticaDatasource{}
+	queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT time, host, cpu, sessions FROM test_table", Format: "time_series_multi"}), &instanceSettings{Db: db}, nil)
+
+	require.Nil(t, queryOutput.Error, "Query shouldn't throw any errors")
+	require.Len(t, queryOutput.Frames, 6, "One frame should be created per series and value field")
+
+	expected := []struct {
+		name   string
+		labels data.Labels
+		times  []time.Time
+	}{
+		{name: "cpu", labels: data.Labels{"host": "node1"}, times: []time.Time{t1, t2}},
+		{name: "sessions", labels: data.Labels{"host": "node1"}, times: []time.Time{t1, t2}},
+		{name: "cpu", labels: data.Labels{"host": "node2"}, times: []time.Time{t1}},
+		{name: "sessions", labels: data.Labels{"host": "node2"}, times: []time.Time{t1}},
+		{name: "cpu", labels: data.Labels{}, times: []time.Time{t2}},
+		{name: "sessions", labels: data.Labels{}, times: []time.Time{t2}},
+	}
+
+	for i, frame := range queryOutput.Frames {
+		require.Equal(t, data.FrameTypeTimeSeriesMulti, frame.Meta.Type, "Invalid frame type")
+		require.Equal(t, "SELECT time, host, cpu, sessions FROM test_table", frame.Meta.ExecutedQueryString, "Invalid frame SQL")
+		require.Len(t, frame.Fields, 2, "Series frames should have a time and a value field")
+		require.Equal(t, expected[i].name, frame.Fields[1].Name, "Invalid value field")
+		require.Equal(t, expected[i].labels, frame.Fields[1].Labels, "Invalid series labels")
+		require.Equal(t, len(expected[i].times), frame.Rows(), "Invalid number of rows")
+		for row, expectedTime := range expected[i].times {
+			require.Equal(t, expectedTime, *frame.Fields[0].At(row).(*time.Time), "Invalid time of the series")
+		}
+	}
+	require.Equal(t, 20.5, *queryOutput.Frames[2].Fields[1].At(0).(*float64), "Invalid value of the series")
+}
+
+func Test_ToMultiFrames_Empty(t *testing.T) {
+
+	fmt.Println("Empty Multi Frame Time Series Tests")
+
+	frame := data.NewFrame("response",
+		data.NewField("time", nil, []*time.Time{}),
+		data.NewField("value", nil, []*float64{}),
+	).SetMeta(&data.FrameMeta{ExecutedQueryString: "SELECT 1"})
+
+	frames := toMultiFrames(frame)
+
+	require.Len(t, frames, 1, "An empty frame should be returned for empty results")
+	require.Empty(t, frames[0].Fields, "The empty frame shouldn't have fields")
+	require.Equal(t, data.FrameTypeTimeSeriesMulti, frames[0].Meta.Type, "Invalid frame type")
+}
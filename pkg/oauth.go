@@ -0,0 +1,227 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	vertica "github.com/vertica/vertica-sql-go"
+)
+
+// Names of the secure settings holding the credentials of the OAuth token requests.
+const (
+	secureOauthClientSecret = "oauthClientSecret"
+	secureOauthRefreshToken = "oauthRefreshToken"
+)
+
+// An access token is refreshed when it expires in less than this margin, so that it
+// does not expire while a connection is being established.
+const oauthRefreshMargin = 30 * time.Second
+
+// Maximum duration of the OAuth token requests.
+const oauthRequestTimeout = 30 * time.Second
+
+// oauthTokenSource gets the OAuth access tokens of the connections of a datasource from
+// the token endpoint, with the refresh token grant when a refresh token is configured
+// and the client credentials grant otherwise. The access token is cached until it
+// is about to expire.
+type oauthTokenSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	httpClient   *http.Client
+	now          func() time.Time
+
+	mu           sync.Mutex
+	refreshToken string
+	accessToken  string
+	expiry       time.Time
+}
+
+// oauthTokenResponse is the successful response, or the error response, of the token endpoint.
+type oauthTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Function to create the OAuth token source of the datasource settings, nil when no
+// token endpoint is configured and the static access token is used.
+func newOauthTokenSource(config configArgs, secureData map[string]string) (*oauthTokenSource, error) {
+	if config.OauthTokenURL == "" {
+		return nil, nil
+	}
+	tokenURL, err := url.Parse(config.OauthTokenURL)
+	if err != nil || (tokenURL.Scheme != "http" && tokenURL.Scheme != "https") || tokenURL.Host == "" {
+		return nil, fmt.Errorf("invalid OAuth token URL %q", config.OauthTokenURL)
+	}
+	tokens := &oauthTokenSource{
+		tokenURL:     config.OauthTokenURL,
+		clientID:     config.OauthClientID,
+		clientSecret: secureData[secureOauthClientSecret],
+		scopes:       config.OauthScopes,
+		refreshToken: secureData[secureOauthRefreshToken],
+		httpClient:   &http.Client{Timeout: oauthRequestTimeout},
+		now:          time.Now,
+	}
+	if tokens.refreshToken == "" && (tokens.clientID == "" || tokens.clientSecret == "") {
+		return nil, fmt.Errorf("the OAuth client credentials need a client ID and secret, or a refresh token")
+	}
+	return tokens, nil
+}
+
+// Function to get a valid access token, requesting a new one when the cached one
+// is missing or about to expire.
+func (tokens *oauthTokenSource) Token(ctx context.Context) (string, error) {
+	tokens.mu.Lock()
+	defer tokens.mu.Unlock()
+
+	if tokens.accessToken != "" && (tokens.expiry.IsZero() || tokens.now().Add(oauthRefreshMargin).Before(tokens.expiry)) {
+		return tokens.accessToken, nil
+	}
+	response, err := tokens.request(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the OAuth access token: %w", err)
+	}
+	tokens.accessToken = response.AccessToken
+	tokens.expiry = time.Time{}
+	if response.ExpiresIn > 0 {
+		tokens.expiry = tokens.now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+	// The token endpoint may rotate the refresh token.
+	if response.RefreshToken != "" {
+		tokens.refreshToken = response.RefreshToken
+	}
+	log.DefaultLogger.Debug("OAuth access token refreshed", "expiry", tokens.expiry)
+	return tokens.accessToken, nil
+}
+
+// Function to request an access token from the token endpoint.
+func (tokens *oauthTokenSource) request(ctx context.Context) (*oauthTokenResponse, error) {
+	form := url.Values{}
+	if tokens.refreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", tokens.refreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(tokens.scopes) > 0 {
+		form.Set("scope", strings.Join(tokens.scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokens.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if tokens.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(tokens.clientID), url.QueryEscape(tokens.clientSecret))
+	}
+
+	resp, err := tokens.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var response oauthTokenResponse
+	jsonErr := json.Unmarshal(body, &response)
+	if resp.StatusCode != http.StatusOK {
+		if jsonErr == nil && response.Error != "" {
+			if response.ErrorDescription != "" {
+				return nil, fmt.Errorf("%s: %s", response.Error, response.ErrorDescription)
+			}
+			return nil, fmt.Errorf("%s", response.Error)
+		}
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+	if jsonErr != nil {
+		return nil, fmt.Errorf("invalid token response: %w", jsonErr)
+	}
+	if response.AccessToken == "" {
+		return nil, fmt.Errorf("token response without access token")
+	}
+	return &response, nil
+}
+
+// accessTokenSource provides the OAuth access tokens of new connections.
+type accessTokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// oauthConnector opens the connections of a datasource using the OAuth tokens of its
+// token source. Each new connection is opened with a connection URL holding the
+// current access token, the open sessions are kept when their token expires.
+type oauthConnector struct {
+	config      configArgs
+	password    string
+	tokens      accessTokenSource
+	dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Connect opens a connection with the current access token.
+func (c *oauthConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := vertica.NewConnector(c.config.ConnectionURL(c.password, token), c.dialContext)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+// Driver returns the vertica driver.
+func (c *oauthConnector) Driver() driver.Driver {
+	return &vertica.Driver{}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"bou.ke/monkey"
+	"github.com/stretchr/testify/require"
+	vertica "github.com/vertica/vertica-sql-go"
+)
+
+// fakeOauthServer is a token endpoint issuing numbered access tokens.
+type fakeOauthServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  []url.Values
+	expiresIn int64
+	failWith  string
+}
+
+// Function to start a fake token endpoint accepting the grizzly client and the refresh
+// tokens it issued, rotating the refresh token on every request.
+func newFakeOauthServer(t *testing.T, expiresIn int64) *fakeOauthServer {
+	server := &fakeOauthServer{expiresIn: expiresIn}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		require.Nil(t, r.ParseForm(), "Token request should be a form")
+		server.requests = append(server.requests, r.PostForm)
+		w.Header().Set("Content-Type", "application/json")
+
+		// The client credentials are form encoded in the basic authentication, per RFC 6749.
+		clientID, clientSecret, _ := r.BasicAuth()
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+		grantType := r.PostForm.Get("grant_type")
+		switch {
+		case server.failWith != "":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": server.failWith, "error_description": "request rejected"})
+			return
+		case grantType == "client_credentials" && (clientID != "grizzly" || clientSecret != "s3cr%t&"):
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		case grantType == "refresh_token" && r.PostForm.Get("refresh_token") != fmt.Sprintf("refresh-%d", len(server.requests)-1):
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  fmt.Sprintf("token-%d", len(server.requests)),
+			"token_type":    "Bearer",
+			"expires_in":    server.expiresIn,
+			"refresh_token": fmt.Sprintf("refresh-%d", len(server.requests)),
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_NewOauthTokenSource(t *testing.T) {
+
+	fmt.Println("OAuth Token Source Validation Tests")
+
+	tests := []struct {
+		name         string
+		config       configArgs
+		secureData   map[string]string
+		expectingNil bool
+		expectingErr error
+	}{
+		{
+			name:         "Static access token",
+			config:       configArgs{},
+			expectingNil: true,
+		},
+		{
+			name:       "Client credentials",
+			config:     configArgs{OauthTokenURL: "https://idp.example.com/token", OauthClientID: "grizzly"},
+			secureData: map[string]string{secureOauthClientSecret: "secret"},
+		},
+		{
+			name:       "Refresh token",
+			config:     configArgs{OauthTokenURL: "https://idp.example.com/token"},
+			secureData: map[string]string{secureOauthRefreshToken: "refresh"},
+		},
+		{
+			name:         "Invalid token URL",
+			config:       configArgs{OauthTokenURL: "idp.example.com/token", OauthClientID: "grizzly"},
+			secureData:   map[string]string{secureOauthClientSecret: "secret"},
+			expectingErr: fmt.Errorf(`invalid OAuth token URL "idp.example.com/token"`),
+		},
+		{
+			name:         "Client credentials without secret",
+			config:       configArgs{OauthTokenURL: "https://idp.example.com/token", OauthClientID: "grizzly"},
+			expectingErr: fmt.Errorf("the OAuth client credentials need a client ID and secret, or a refresh token"),
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			tokens, err := newOauthTokenSource(tc.config, tc.secureData)
+			if tc.expectingErr != nil {
+				require.EqualError(tt, err, tc.expectingErr.Error(), "Invalid error")
+				return
+			}
+			require.Nil(tt, err, "Valid OAuth settings shouldn't throw any errors")
+			require.Equal(tt, tc.expectingNil, tokens == nil, "Invalid token source")
+		})
+	}
+}
+
+func Test_OauthTokenSource(t *testing.T) {
+
+	fmt.Println("OAuth Token Refresh Tests")
+
+	t.Run("Client credentials token is cached until it is about to expire", func(tt *testing.T) {
+		server := newFakeOauthServer(tt, 300)
+		tokens, err := newOauthTokenSource(configArgs{OauthTokenURL: server.URL, OauthClientID: "grizzly", OauthScopes: []string{"vertica", "read"}}, map[string]string{secureOauthClientSecret: "s3cr%t&"})
+		require.Nil(tt, err)
+		now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+		tokens.now = func() time.Time { return now }
+
+		token, err := tokens.Token(context.Background())
+		require.Nil(tt, err, "Token request shouldn't throw any errors")
+		require.Equal(tt, "token-1", token)
+		require.Equal(tt, "client_credentials", server.requests[0].Get("grant_type"))
+		require.Equal(tt, "vertica read", server.requests[0].Get("scope"))
+
+		now = now.Add(4 * time.Minute)
+		token, err = tokens.Token(context.Background())
+		require.Nil(tt, err)
+		require.Equal(tt, "token-1", token, "Token should be cached")
+
+		// Within the refresh margin of the expiry, the rotated refresh token is used.
+		now = now.Add(40 * time.Second)
+		token, err = tokens.Token(context.Background())
+		require.Nil(tt, err)
+		require.Equal(tt, "token-2", token, "Token should be refreshed before its expiry")
+		require.Equal(tt, "refresh_token", server.requests[1].Get("grant_type"))
+		require.Equal(tt, "refresh-1", server.requests[1].Get("refresh_token"))
+		require.Len(tt, server.requests, 2, "Invalid number of token requests")
+	})
+
+	t.Run("Refresh token grant", func(tt *testing.T) {
+		server := newFakeOauthServer(tt, 0)
+		// The fake server issued refresh-0 before the first request.
+		tokens, err := newOauthTokenSource(configArgs{OauthTokenURL: server.URL}, map[string]string{secureOauthRefreshToken: "refresh-0"})
+		require.Nil(tt, err)
+
+		token, err := tokens.Token(context.Background())
+		require.Nil(tt, err, "Token request shouldn't throw any errors")
+		require.Equal(tt, "token-1", token)
+		// Without expiry the token is kept.
+		token, err = tokens.Token(context.Background())
+		require.Nil(tt, err)
+		require.Equal(tt, "token-1", token)
+		require.Len(tt, server.requests, 1, "Invalid number of token requests")
+	})
+
+	t.Run("Token endpoint errors", func(tt *testing.T) {
+		server := newFakeOauthServer(tt, 300)
+		server.failWith = "invalid_scope"
+		tokens, err := newOauthTokenSource(configArgs{OauthTokenURL: server.URL, OauthClientID: "grizzly"}, map[string]string{secureOauthClientSecret: "s3cr%t&"})
+		require.Nil(tt, err)
+
+		_, err = tokens.Token(context.Background())
+		require.EqualError(tt, err, "failed to get the OAuth access token: invalid_scope: request rejected")
+
+		tokens.clientSecret = "wrong"
+		server.failWith = ""
+		_, err = tokens.Token(context.Background())
+		require.EqualError(tt, err, "failed to get the OAuth access token: invalid_client")
+	})
+}
+
+func Test_OauthConnector(t *testing.T) {
+
+	fmt.Println("OAuth Connector Tests")
+
+	server := newFakeOauthServer(t, 300)
+	tokens, err := newOauthTokenSource(configArgs{OauthTokenURL: server.URL, OauthClientID: "grizzly"}, map[string]string{secureOauthClientSecret: "s3cr%t&"})
+	require.Nil(t, err)
+	now := time.Now()
+	tokens.now = func() time.Time { return now }
+
+	// The connection URLs are captured instead of connecting to a database.
+	var connStrs []string
+	monkey.Patch(vertica.NewConnector, func(connStr string, _ func(ctx context.Context, network, addr string) (net.Conn, error)) (*vertica.Connector, error) {
+		connStrs = append(connStrs, connStr)
+		return nil, errors.New("no database")
+	})
+	defer monkey.UnpatchAll()
+
+	connector := &oauthConnector{config: configArgs{User: "dbadmin", URL: "localhost:5433", Database: "VMart"}, tokens: tokens}
+	for i := 0; i < 3; i++ {
+		_, err = connector.Connect(context.Background())
+		require.EqualError(t, err, "no database")
+		now = now.Add(3 * time.Minute)
+	}
+
+	var accessTokens []string
+	for _, connStr := range connStrs {
+		connURL, err := url.Parse(connStr)
+		require.Nil(t, err, "Connection URL should be valid")
+		accessTokens = append(accessTokens, connURL.Query().Get("oauth_access_token"))
+	}
+	require.Equal(t, []string{"token-1", "token-1", "token-2"}, accessTokens, "New connections should use the refreshed token")
+}
@@ -36,6 +36,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -50,7 +51,7 @@ import (
 var invalidMetricColumnTypes = []string{"date", "timestamp", "timestamptz", "time", "timetz", "bigint", "int", "smallint", "mediumint", "tinyint", "double", "decimal", "float"}
 
 // Query is primary method of handling requests.
-func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery, connDB *sql.DB) backend.DataResponse {
+func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery, instance *instanceSettings, attribution *sessionAttribution) backend.DataResponse {
 
 	log.DefaultLogger.Debug("Inside query.query Function")
 
@@ -62,38 +63,93 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 	var queryArgs queryModel
 	json.Unmarshal(query.JSON, &queryArgs)
 
-	// Check if the timeGroup macro is present in the rawSQL or not.
-	isTimeGroupMacro := strings.Contains(queryArgs.RawSQL, "$__timeGroup")
-
-	// Assign the default fill mode value to fillMode variable.
-	fillMode = data.FillModeNull
-
 	// Log a warning if `Format` is empty.
 	if queryArgs.Format == "" {
 		log.DefaultLogger.Warn("Format is empty. defaulting to time series")
 	}
 
-	queryArgs.RawSQL, response.Error = sanitizeAndInterpolateMacros(queryArgs.RawSQL, query)
+	var macroCtx *macroContext
+	queryArgs.RawSQL, macroCtx, response.Error = sanitizeAndInterpolateMacros(queryArgs.RawSQL, query, instance)
 	log.DefaultLogger.Debug("Sanitized final raw query: " + queryArgs.RawSQL)
 
 	if response.Error != nil {
 		log.DefaultLogger.Error("Error while sanitizing the query: " + response.Error.Error())
 		return response
 	}
-	connection, err := connDB.Conn(ctx)
+
+	// Fill mode for the NULL values returned by Vertica.
+	fillMode, fillValue := data.FillModeNull, 0.0
+	if macroCtx.fillMissing != nil {
+		fillMode, fillValue = macroCtx.fillMissing.Mode, macroCtx.fillMissing.Value
+	}
+
+	// The timeout of the query overrides the default timeout of the datasource.
+	timeout := queryTimeout{seconds: instance.config.QueryTimeout, parent: ctx}
+	if queryArgs.QueryTimeout > 0 {
+		timeout.seconds = queryArgs.QueryTimeout
+	}
+	if timeout.seconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout.seconds)*time.Second)
+		defer cancel()
+	}
+
+	connection, err := instance.Db.Conn(ctx)
 	if err != nil {
 		log.DefaultLogger.Error(fmt.Sprintf("queryData :connection: %s", err))
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out getting a connection to Vertica, the connections of the data source may all be in use: %w", err)
+		}
 		response.Error = err
 		return response
 	}
 	defer connection.Close()
+
+	if timeout.seconds > 0 {
+		// Let Vertica cancel the statement as well, in case the client side deadline is missed.
+		// Users cannot raise it above their own runtime cap, the client side deadline still applies then.
+		if _, err := connection.ExecContext(ctx, fmt.Sprintf("SET SESSION RUNTIMECAP '%d seconds'", timeout.seconds)); err != nil {
+			log.DefaultLogger.Warn("Could not set the runtime cap, only the query timeout applies: " + err.Error())
+		} else {
+			timeout.runtimeCap = true
+			defer resetRuntimeCap(connection)
+		}
+	}
+
+	// Attribute the query to the Grafana user with the client label and role of the session.
+	resetAttribution, err := attribution.apply(ctx, connection)
+	if err != nil {
+		log.DefaultLogger.Error(err.Error())
+		response.Error = err
+		return response
+	}
+	defer resetAttribution()
+
+	// Track the Vertica session of a query which can be cancelled, so that its statement
+	// is interrupted when the query is cancelled. The session is fetched once for each
+	// connection, and not at all for the queries which cannot be cancelled.
+	if ctx.Done() != nil && instance.interruptDb != nil {
+		if sessionID, err := instance.sessions.sessionID(ctx, connection); err != nil {
+			log.DefaultLogger.Warn("Could not fetch the Vertica session, the query will not be interrupted on cancellation: " + err.Error())
+		} else {
+			defer watchCancellation(ctx, instance.interruptDb, sessionID)()
+		}
+	}
+
+	// Keep all the digits of the wide NUMERIC columns when the datasource asks for it.
+	if instance.config.NumericAsString {
+		queryArgs.RawSQL = castWideNumerics(ctx, connection, queryArgs.RawSQL)
+	}
+
 	// Excute the query
 	var rows *sql.Rows
-	rows, response.Error = connection.QueryContext(ctx, queryArgs.RawSQL)
-	if response.Error != nil {
-		log.DefaultLogger.Error("Error while fetching the Query Result", response.Error)
+	rows, err = connection.QueryContext(ctx, queryArgs.RawSQL)
+	if err != nil {
+		log.DefaultLogger.Error("Error while fetching the Query Result", err)
+		setQueryError(ctx, &response, err, timeout)
 		return response
 	}
+	defer rows.Close()
 
 	// Add sql query in the data frame
 	frame.Meta = &data.FrameMeta{ExecutedQueryString: queryArgs.RawSQL}
@@ -104,7 +160,7 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 	columns := make([]*sqlColumn, columnCount)
 
 	for idx := range columns {
-		columns[idx] = &sqlColumn{Name: columnTypes[idx].Name()}
+		columns[idx] = &sqlColumn{Name: columnTypes[idx].Name(), DatabaseType: columnTypes[idx].DatabaseTypeName()}
 
 		// If the column alias is metric then column type should not be from invalidMetricColumnTypes.
 		if columns[idx].Name == "metric" {
@@ -114,22 +170,12 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 			}
 		}
 
-		if columns[idx].Name == "time" && isTimeGroupMacro {
+		if columns[idx].Name == "time" && macroCtx.timeGroup {
 			// If the column alias is time and macro is timeGroup, column type should be TIME.
 			columns[idx].Type = "TIME"
 		} else {
-			switch strings.ToUpper(columnTypes[idx].DatabaseTypeName()) {
-			case "INTEGER", "INT":
-				columns[idx].Type = "INTEGER"
-			case "REAL", "NUMERIC", "DOUBLE", "FLOAT":
-				columns[idx].Type = "FLOAT"
-			case "NULL", "TEXT", "BLOB", "VARCHAR", "LONG VARCHAR", "CHAR", "UUID", "VARBINARY", "LONG VARBINARY", "BINARY":
-				columns[idx].Type = "STRING"
-			case "TIME", "TIMESTAMP", "TIMESTAMPTZ":
-				columns[idx].Type = "TIME"
-			case "BOOL", "BOOLEAN":
-				columns[idx].Type = "BOOLEAN"
-			default:
+			columns[idx].Type, columns[idx].Config = mapColumnType(columnTypes[idx])
+			if columns[idx].Type == "UNKNOWN" {
 				log.DefaultLogger.Debug(
 					"Unknown database type",
 					"type",
@@ -137,14 +183,21 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 					"column",
 					columnTypes[idx].Name(),
 				)
-				columns[idx].Type = "UNKNOWN"
 			}
 		}
 
 	}
 
-	// Fetching the rows
+	// Fetching the rows, up to the row and byte limits of the datasource.
+	rowCount := 0
+	var responseBytes int64
+	truncated := ""
 	for rows.Next() {
+		if instance.config.MaxRows > 0 && rowCount >= instance.config.MaxRows {
+			truncated = fmt.Sprintf("result truncated at %d rows", rowCount)
+			break
+		}
+
 		values := make([]interface{}, columnCount)
 		valuePointers := make([]interface{}, columnCount)
 
@@ -154,43 +207,29 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 
 		if err := rows.Scan(valuePointers...); err != nil {
 			log.DefaultLogger.Error("Could not scan row", "err", err)
-			response.Error = err
+			setQueryError(ctx, &response, err, timeout)
 			return response
 		}
 
+		if instance.config.MaxResponseBytes > 0 {
+			responseBytes += rowSize(values)
+			if responseBytes > instance.config.MaxResponseBytes {
+				truncated = fmt.Sprintf("result truncated at %d rows, the response exceeded %d bytes", rowCount, instance.config.MaxResponseBytes)
+				break
+			}
+		}
+		rowCount++
+
 		// Storing the entries as per the dataType of Column
 		for i, column := range columns {
-			var intV int64
-			var floatV float64
-			var stringV string
-			var timeV time.Time
-			valueType := ""
-
-			switch v := values[i].(type) {
-			case int8, int16, int32, int64:
-				valueType = "INTEGER"
-				intV = v.(int64)
-			case int:
-				valueType = "INTEGER"
-				intV = int64(v)
-			case float32, float64:
-				valueType = "FLOAT"
-				floatV = v.(float64)
-			case []byte, string:
-				valueType = "STRING"
-				stringV = v.(string)
-			case time.Time:
-				valueType = "TIME"
-				timeV = v
-			case nil:
-				valueType = "NULL"
-			default:
+			scanned := convertValue(values[i])
+			valueType, intV, floatV, stringV, timeV, boolV := scanned.Type, scanned.Int, scanned.Float, scanned.String, scanned.Time, scanned.Bool
+			if valueType == "UNKNOWN" {
 				log.DefaultLogger.Warn(
 					"Scanned row value type was unexpected",
 					"value", values[i], "type", fmt.Sprintf("%T", values[i]),
 					"column", column.Name,
 				)
-				valueType = "UNKNOWN"
 			}
 
 			if column.Type == "UNKNOWN" && valueType != "NULL" {
@@ -198,6 +237,9 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 				// fill a typed list later. Multiple types are not allowed
 				if valueType == "UNKNOWN" {
 					column.Type = "STRING"
+				} else if valueType == "STRING" && isComplexValue(stringV) {
+					// Complex types unknown to the driver are returned as JSON text.
+					column.Type = "JSON"
 				} else {
 					column.Type = valueType
 				}
@@ -217,7 +259,7 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 				} else if valueType == "FLOAT" {
 					value = time.Unix(int64(floatV), 0)
 				} else if valueType != "NULL" {
-					val := fmt.Sprintf("%v", values[i])
+					val := stringV
 					value, err = time.Parse(time.RFC3339, val)
 					if err != nil {
 						// try parsing the string as a number
@@ -272,7 +314,7 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 							columns[i].IntData = append(columns[i].IntData, nil)
 						}
 					} else if fillMode == data.FillModeValue {
-						value = 0
+						value = int64(fillValue)
 						columns[i].IntData = append(columns[i].IntData, &value)
 					} else {
 						columns[i].IntData = append(columns[i].IntData, nil)
@@ -283,7 +325,17 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 				continue
 			}
 
-			if column.Type == "FLOAT" {
+			if column.Type == "DURATION" && valueType == "STRING" {
+				// Intervals are returned as strings, they are stored in seconds.
+				if seconds, err := parseInterval(stringV, strings.TrimPrefix(strings.ToUpper(column.DatabaseType), "INTERVAL")); err == nil {
+					valueType, floatV = "FLOAT", seconds
+				} else {
+					log.DefaultLogger.Warn("Could not convert interval to seconds", "value", stringV, "err", err)
+					valueType = "NULL"
+				}
+			}
+
+			if column.Type == "FLOAT" || column.Type == "DURATION" {
 				var value float64
 				var err error
 
@@ -309,7 +361,7 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 							columns[i].FloatData = append(columns[i].FloatData, nil)
 						}
 					} else if fillMode == data.FillModeValue {
-						value = 0
+						value = fillValue
 						columns[i].FloatData = append(columns[i].FloatData, &value)
 					} else {
 						columns[i].FloatData = append(columns[i].FloatData, nil)
@@ -324,7 +376,9 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 				var value bool
 				var err error
 
-				if valueType == "INTEGER" {
+				if valueType == "BOOLEAN" {
+					value = boolV
+				} else if valueType == "INTEGER" {
 					value, err = strconv.ParseBool(fmt.Sprintf("%d", intV))
 					if err != nil {
 						log.DefaultLogger.Warn("Could not convert value to bool", "value", intV)
@@ -352,6 +406,21 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 				continue
 			}
 
+			if column.Type == "JSON" {
+				if valueType == "NULL" {
+					columns[i].JSONData = append(columns[i].JSONData, nil)
+					continue
+				}
+				value, err := complexValue(scanned, values[i])
+				if err != nil {
+					log.DefaultLogger.Warn("Could not convert value to JSON", "value", values[i], "err", err)
+					columns[i].JSONData = append(columns[i].JSONData, nil)
+				} else {
+					columns[i].JSONData = append(columns[i].JSONData, &value)
+				}
+				continue
+			}
+
 			if column.Type == "STRING" {
 				var value string
 
@@ -359,6 +428,8 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 					value = fmt.Sprintf("%d", intV)
 				} else if valueType == "FLOAT" {
 					value = fmt.Sprintf("%f", floatV)
+				} else if valueType == "STRING" || valueType == "UNKNOWN" {
+					value = stringV
 				} else {
 					value = fmt.Sprintf("%v", values[i])
 				}
@@ -388,34 +459,45 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 			columns[i].BoolData = append(columns[i].BoolData, nil)
 			columns[i].FloatData = append(columns[i].FloatData, nil)
 			columns[i].StringData = append(columns[i].StringData, nil)
+			columns[i].JSONData = append(columns[i].JSONData, nil)
 
 		}
 
 	}
 
+	if err := rows.Err(); err != nil {
+		log.DefaultLogger.Error("Error while fetching the Query Result", err)
+		setQueryError(ctx, &response, err, timeout)
+		return response
+	}
+
 	// Appending all the fetched data into Frames
 	for _, column := range columns {
+		var field *data.Field
 		switch column.Type {
 		case "TIME":
-			frame.Fields = append(
-				frame.Fields, data.NewField(column.Name, nil, column.TimeData),
-			)
-		case "FLOAT":
-			frame.Fields = append(
-				frame.Fields, data.NewField(column.Name, nil, column.FloatData),
-			)
+			field = data.NewField(column.Name, nil, column.TimeData)
+		case "FLOAT", "DURATION":
+			field = data.NewField(column.Name, nil, column.FloatData)
 		case "INTEGER":
-			frame.Fields = append(
-				frame.Fields, data.NewField(column.Name, nil, column.IntData),
-			)
+			field = data.NewField(column.Name, nil, column.IntData)
 		case "BOOLEAN":
-			frame.Fields = append(
-				frame.Fields, data.NewField(column.Name, nil, column.BoolData),
-			)
+			field = data.NewField(column.Name, nil, column.BoolData)
+		case "JSON":
+			field = data.NewField(column.Name, nil, column.JSONData)
 		default:
-			frame.Fields = append(
-				frame.Fields, data.NewField(column.Name, nil, column.StringData),
-			)
+			field = data.NewField(column.Name, nil, column.StringData)
+		}
+		frame.Fields = append(frame.Fields, field.SetConfig(column.Config))
+	}
+
+	// The row limit of the datasource applies to the frames of the response as well,
+	// which grow with the expanded arrays and the resampled time series.
+	rowsTruncated := fmt.Sprintf("result truncated at %d rows", instance.config.MaxRows)
+	if queryArgs.ExpandComplexTypes {
+		var expandTruncated bool
+		if frame, expandTruncated = expandComplexFields(frame, instance.config.MaxRows); expandTruncated && truncated == "" {
+			truncated = rowsTruncated
 		}
 	}
 
@@ -423,19 +505,147 @@ func (v *VerticaDatasource) query(ctx context.Context, query backend.DataQuery,
 	//this use full when the user writes a variable query
 	if queryArgs.Format == "table" || frame.TimeSeriesSchema().Type == data.TimeSeriesTypeNot {
 		response.Frames = append(response.Frames, frame)
+	} else if queryArgs.Format == "time_series_multi" {
+		seriesRows := 0
+		for _, seriesFrame := range toMultiFrames(frame) {
+			if instance.config.MaxRows > 0 && seriesRows >= instance.config.MaxRows {
+				if truncated == "" {
+					truncated = rowsTruncated
+				}
+				break
+			}
+			if macroCtx.fillMissing != nil && seriesFrame.Rows() > 0 {
+				var resampleTruncated bool
+				if seriesFrame, resampleTruncated = resample(seriesFrame, macroCtx.interval, macroCtx.timeRange, macroCtx.fillMissing, instance.config.MaxRows); resampleTruncated && truncated == "" {
+					truncated = rowsTruncated
+				}
+			}
+			seriesRows += seriesFrame.Rows()
+			response.Frames = append(response.Frames, seriesFrame)
+		}
 	} else if frame.TimeSeriesSchema().Type == data.TimeSeriesTypeWide {
+		if macroCtx.fillMissing != nil {
+			var resampleTruncated bool
+			if frame, resampleTruncated = resample(frame, macroCtx.interval, macroCtx.timeRange, macroCtx.fillMissing, instance.config.MaxRows); resampleTruncated && truncated == "" {
+				truncated = rowsTruncated
+			}
+		}
 		response.Frames = append(response.Frames, frame)
 	} else if frame.Rows() == 0 {
 		response.Frames = append(response.Frames, data.NewFrame("Long"))
 	} else {
-		wideFrame, err := data.LongToWide(frame, nil)
+		wideFrame, err := data.LongToWide(frame, macroCtx.fillMissing)
 		if err != nil {
 			log.DefaultLogger.Error("Error while rendering the time-series data" + err.Error())
 			response.Error = err
 			return response
 		}
+		if macroCtx.fillMissing != nil {
+			var resampleTruncated bool
+			if wideFrame, resampleTruncated = resample(wideFrame, macroCtx.interval, macroCtx.timeRange, macroCtx.fillMissing, instance.config.MaxRows); resampleTruncated && truncated == "" {
+				truncated = rowsTruncated
+			}
+		}
 		response.Frames = append(response.Frames, wideFrame)
 	}
 
+	var rowsLimited bool
+	if response.Frames, rowsLimited = limitFrameRows(response.Frames, instance.config.MaxRows); rowsLimited && truncated == "" {
+		truncated = rowsTruncated
+	}
+	if truncated != "" {
+		log.DefaultLogger.Warn("Query result truncated", "refId", query.RefID, "reason", truncated)
+		for _, f := range response.Frames {
+			f.AppendNotices(data.Notice{Severity: data.NoticeSeverityWarning, Text: truncated})
+		}
+	}
+
 	return response
 }
+
+// Function to limit the total number of rows of the frames of a response to maxRows
+// when it is positive, truncating the frames in order and leaving out the frames past
+// the limit. It returns the frames kept and reports whether rows were left out.
+func limitFrameRows(frames []*data.Frame, maxRows int) ([]*data.Frame, bool) {
+	if maxRows <= 0 {
+		return frames, false
+	}
+	truncated := false
+	remaining := maxRows
+	for n, frame := range frames {
+		if remaining == 0 {
+			return frames[:n], true
+		}
+		if rows := frame.Rows(); rows > remaining {
+			for i, field := range frame.Fields {
+				limited := data.NewFieldFromFieldType(field.Type(), remaining)
+				limited.Name, limited.Labels, limited.Config = field.Name, field.Labels, field.Config
+				for row := 0; row < remaining; row++ {
+					limited.Set(row, field.CopyAt(row))
+				}
+				frame.Fields[i] = limited
+			}
+			truncated = true
+		}
+		remaining -= frame.Rows()
+	}
+	return frames, truncated
+}
+
+// Function to estimate the size of a scanned row in the response.
+func rowSize(values []interface{}) int64 {
+	var size int64
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			size += int64(len(v))
+		case []byte:
+			size += int64(len(v))
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// Function to reset the runtime cap of the session before the connection is returned to the pool.
+func resetRuntimeCap(connection *sql.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), interruptTimeout)
+	defer cancel()
+	if _, err := connection.ExecContext(ctx, "SET SESSION RUNTIMECAP = DEFAULT"); err != nil {
+		log.DefaultLogger.Warn("Error while resetting the runtime cap: " + err.Error())
+	}
+}
+
+// queryTimeout is the timeout of a query, set by the query or by its datasource.
+type queryTimeout struct {
+	seconds int
+	// parent is the context of the request, whose own deadline is not the timeout of the query.
+	parent context.Context
+	// runtimeCap tells whether the runtime cap of the Vertica session is set to the timeout.
+	runtimeCap bool
+}
+
+// Function to check whether a query failed because it exceeded its own timeout, either
+// the client side deadline or the Vertica runtime cap, rather than the deadline of the
+// request.
+func (timeout queryTimeout) exceeded(ctx context.Context, err error) bool {
+	if timeout.seconds <= 0 {
+		return false
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return timeout.parent.Err() == nil
+	}
+	return timeout.runtimeCap && strings.Contains(strings.ToLower(err.Error()), "run time cap")
+}
+
+// Function to set the error of a query on the response. Queries which exceeded their
+// own timeout are reported as timeouts.
+func setQueryError(ctx context.Context, response *backend.DataResponse, err error, timeout queryTimeout) {
+	if timeout.exceeded(ctx, err) {
+		response.Error = fmt.Errorf("query exceeded %d s timeout", timeout.seconds)
+		response.Status = backend.StatusTimeout
+		return
+	}
+	response.Error = err
+}
@@ -7,11 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
+
 	"bou.ke/monkey"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/stretchr/testify/require"
 )
@@ -59,7 +63,7 @@ func Test_Query(t *testing.T) {
 			dataQuery:     getDataQuery(queryModel{RawSQL: "test"}),
 			fieldsCount:   0,
 			rowsPerField:  0,
-			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testurl",BackupServerNode:"host1:port1,host2:port", UsePreparedStmts: false, UseLoadBalancer: false,MaxOpenConnections: 2, MaxIdealConnections: 2}),
+			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testurl", BackupServerNode: "host1:port1,host2:port", UsePreparedStmts: false, UseLoadBalancer: false, MaxOpenConnections: 2, MaxIdealConnections: 2}),
 			expectedReponse: backend.DataResponse{
 				Error: errors.New("sql error occurred"),
 			},
@@ -71,7 +75,7 @@ func Test_Query(t *testing.T) {
 			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT 1"}),
 			fieldsCount:   1,
 			rowsPerField:  1,
-			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testurl",BackupServerNode:"host1:port1,host2:port", UsePreparedStmts: false, UseLoadBalancer: false, MaxOpenConnections: 2, MaxIdealConnections: 2}),
+			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testurl", BackupServerNode: "host1:port1,host2:port", UsePreparedStmts: false, UseLoadBalancer: false, MaxOpenConnections: 2, MaxIdealConnections: 2}),
 			expectedReponse: backend.DataResponse{
 				Frames: data.Frames{
 					data.NewFrame("response").SetMeta(&data.FrameMeta{ExecutedQueryString: "SELECT 1"}),
@@ -86,7 +90,7 @@ func Test_Query(t *testing.T) {
 			dataQuery:     getDataQuery(queryModel{RawSQL: "select name, value from table"}),
 			fieldsCount:   2,
 			rowsPerField:  2,
-			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testurl",BackupServerNode:"host1:port1,host2:port", UsePreparedStmts: false, UseLoadBalancer: false, MaxOpenConnections: 2, MaxIdealConnections: 2}),
+			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testurl", BackupServerNode: "host1:port1,host2:port", UsePreparedStmts: false, UseLoadBalancer: false, MaxOpenConnections: 2, MaxIdealConnections: 2}),
 			expectedReponse: backend.DataResponse{
 				Frames: data.Frames{
 					data.NewFrame(
@@ -105,7 +109,7 @@ func Test_Query(t *testing.T) {
 			dataQuery:     getDataQuery(queryModel{RawSQL: "SELECT value, $__time(start_time) FROM test_table"}),
 			fieldsCount:   2,
 			rowsPerField:  3,
-			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testurl",BackupServerNode:"host1:port1,host2:port", MaxOpenConnections: 2, MaxIdealConnections: 2, UsePreparedStmts: false, UseLoadBalancer: false}),
+			pluginContext: getCredentials(configArgs{User: "testUser", Database: "testDB", TLSMode: "none", URL: "testurl", BackupServerNode: "host1:port1,host2:port", MaxOpenConnections: 2, MaxIdealConnections: 2, UsePreparedStmts: false, UseLoadBalancer: false}),
 			expectedReponse: backend.DataResponse{
 				Frames: data.Frames{
 					data.NewFrame(
@@ -147,7 +151,7 @@ func Test_Query(t *testing.T) {
 				return nil
 			})
 
-			queryOutput := v.query(tc.ctx, tc.dataQuery, db)
+			queryOutput := v.query(tc.ctx, tc.dataQuery, &instanceSettings{Db: db}, nil)
 
 			monkey.UnpatchAll()
 
@@ -164,3 +168,297 @@ func Test_Query(t *testing.T) {
 	}
 
 }
+
+func Test_QueryData_ConcurrentFillMode(t *testing.T) {
+
+	fmt.Println("Concurrent Fill Mode Tests")
+
+	const queriesPerFillMode = 20
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.Nil(t, err)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	expectedSQL := "select floor(extract(epoch from ts)/60)*60 as time, value from test_table"
+	for i := 0; i < 2*queriesPerFillMode; i++ {
+		mockCol1 := mock.NewColumn("time").OfType("FLOAT", 0.0)
+		mockCol2 := mock.NewColumn("value").OfType("FLOAT", 0.0)
+		mock.ExpectQuery(expectedSQL).WillReturnRows(mock.NewRowsWithColumnDefinition(mockCol1, mockCol2).AddRow(60.0, 1.5).AddRow(120.0, nil))
+	}
+
+	v := &VerticaDatasource{
+		im: datasource.NewInstanceManager(func(_ context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+			return &instanceSettings{Db: db, Name: settings.Name}, nil
+		}),
+	}
+	pluginContext := getCredentials(configArgs{URL: "testurl", MaxOpenConnections: 4, MaxIdealConnections: 4})
+
+	var wg sync.WaitGroup
+	for i := 0; i < queriesPerFillMode; i++ {
+		for _, fill := range []string{"previous", "NULL"} {
+			wg.Add(1)
+			go func(fill string) {
+				defer wg.Done()
+				rawSQL := fmt.Sprintf("select $__timeGroup(ts, '1m', %s), value from test_table", fill)
+				req := &backend.QueryDataRequest{
+					PluginContext: pluginContext,
+					Queries:       []backend.DataQuery{getDataQuery(queryModel{RawSQL: rawSQL, Format: "table"})},
+				}
+				resp, err := v.QueryData(context.Background(), req)
+				require.Nil(t, err)
+				res := resp.Responses[""]
+				require.Nil(t, res.Error)
+				require.Len(t, res.Frames, 1)
+
+				value := res.Frames[0].Fields[1]
+				require.Equal(t, 2, value.Len())
+				if fill == "previous" {
+					require.Equal(t, 1.5, *value.At(1).(*float64), "Missing value should be filled with the previous value")
+				} else {
+					require.Nil(t, value.At(1), "Missing value should stay NULL")
+				}
+			}(fill)
+		}
+	}
+	wg.Wait()
+}
+
+func Test_Query_Timeout(t *testing.T) {
+
+	fmt.Println("Query Timeout Tests")
+
+	tests := []struct {
+		name          string
+		config        configArgs
+		query         queryModel
+		runtimeCap    string
+		queryErr      error
+		delay         time.Duration
+		expectedError string
+	}{
+		{
+			name:          "Datasource timeout exceeded on the client side",
+			config:        configArgs{QueryTimeout: 1},
+			query:         queryModel{RawSQL: "SELECT sleep(10)", Format: "table"},
+			runtimeCap:    "SET SESSION RUNTIMECAP '1 seconds'",
+			delay:         3 * time.Second,
+			expectedError: "query exceeded 1 s timeout",
+		},
+		{
+			name:          "Query timeout exceeded on the Vertica runtime cap",
+			config:        configArgs{QueryTimeout: 30},
+			query:         queryModel{RawSQL: "SELECT sleep(10)", Format: "table", QueryTimeout: 5},
+			runtimeCap:    "SET SESSION RUNTIMECAP '5 seconds'",
+			queryErr:      errors.New("Error: [57014] Execution time exceeded run time cap of 00:00:05"),
+			expectedError: "query exceeded 5 s timeout",
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.Nil(tt, err)
+			defer db.Close()
+
+			mock.ExpectExec(tc.runtimeCap).WillReturnResult(sqlmock.NewResult(0, 0))
+			expectation := mock.ExpectQuery(tc.query.RawSQL)
+			if tc.queryErr != nil {
+				expectation.WillReturnError(tc.queryErr)
+			} else {
+				expectation.WillDelayFor(tc.delay).WillReturnRows(sqlmock.NewRows([]string{"sleep"}).AddRow(0))
+			}
+			mock.ExpectExec("SET SESSION RUNTIMECAP = DEFAULT").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			v := &VerticaDatasource{}
+			queryOutput := v.query(context.Background(), getDataQuery(tc.query), &instanceSettings{Db: db, config: tc.config}, nil)
+
+			require.NotNil(tt, queryOutput.Error, "Should set error to response if the query timed out")
+			require.Equal(tt, tc.expectedError, queryOutput.Error.Error(), "Timeout error should report the timeout")
+			require.Equal(tt, backend.StatusTimeout, queryOutput.Status, "Timeout error should set the timeout status")
+			require.Nil(tt, mock.ExpectationsWereMet(), "Runtime cap should be set and reset")
+		})
+	}
+}
+
+func Test_Query_Timeout_Cause(t *testing.T) {
+
+	fmt.Println("Query Timeout Cause Tests")
+
+	fmt.Println("Test Name: Request deadline before the query timeout")
+	t.Run("Request deadline before the query timeout", func(tt *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.Nil(tt, err)
+		defer db.Close()
+
+		mock.ExpectExec("SET SESSION RUNTIMECAP '30 seconds'").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT sleep(10)").WillDelayFor(3 * time.Second).WillReturnRows(sqlmock.NewRows([]string{"sleep"}).AddRow(0))
+		mock.ExpectExec("SET SESSION RUNTIMECAP = DEFAULT").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		v := &VerticaDatasource{}
+		queryOutput := v.query(ctx, getDataQuery(queryModel{RawSQL: "SELECT sleep(10)", Format: "table"}), &instanceSettings{Db: db, config: configArgs{QueryTimeout: 30}}, nil)
+
+		require.NotNil(tt, queryOutput.Error, "Should set error to response if the request deadline expired")
+		require.NotContains(tt, queryOutput.Error.Error(), "query exceeded", "The request deadline shouldn't be reported as the query timeout")
+		require.NotEqual(tt, backend.StatusTimeout, queryOutput.Status, "The request deadline shouldn't set the timeout status")
+	})
+
+	fmt.Println("Test Name: Query timeout while waiting for a connection")
+	t.Run("Query timeout while waiting for a connection", func(tt *testing.T) {
+		db, _, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.Nil(tt, err)
+		defer db.Close()
+
+		// The only connection of the pool is in use.
+		db.SetMaxOpenConns(1)
+		busy, err := db.Conn(context.Background())
+		require.Nil(tt, err)
+		defer busy.Close()
+
+		v := &VerticaDatasource{}
+		queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT 1", Format: "table", QueryTimeout: 1}), &instanceSettings{Db: db}, nil)
+
+		require.NotNil(tt, queryOutput.Error, "Should set error to response if no connection is available")
+		require.Equal(tt, "timed out getting a connection to Vertica, the connections of the data source may all be in use: context deadline exceeded", queryOutput.Error.Error(), "Waiting for a connection should be reported")
+		require.NotEqual(tt, backend.StatusTimeout, queryOutput.Status, "Waiting for a connection shouldn't be reported as the query timeout")
+	})
+}
+
+func Test_Query_RuntimeCap_Denied(t *testing.T) {
+
+	fmt.Println("Query Runtime Cap Denied Tests")
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.Nil(t, err)
+	defer db.Close()
+
+	// Non superusers cannot raise the runtime cap above their own one.
+	mock.ExpectExec("SET SESSION RUNTIMECAP '60 seconds'").WillReturnError(errors.New("Error: [42501] Permission denied to raise the runtime cap"))
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+
+	v := &VerticaDatasource{}
+	queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT 1", Format: "table"}), &instanceSettings{Db: db, config: configArgs{QueryTimeout: 60}}, nil)
+
+	require.Nil(t, queryOutput.Error, "Query should run with the client side deadline when the runtime cap is denied")
+	require.Equal(t, 1, queryOutput.Frames[0].Rows(), "Query result should be returned")
+	require.Nil(t, mock.ExpectationsWereMet(), "Runtime cap should not be reset when it was not set")
+}
+
+func Test_Query_Limits(t *testing.T) {
+
+	fmt.Println("Query Limits Tests")
+
+	tests := []struct {
+		name           string
+		config         configArgs
+		expectedRows   int
+		expectedNotice string
+	}{
+		{
+			name:         "No limits",
+			config:       configArgs{},
+			expectedRows: 4,
+		},
+		{
+			name:           "Result truncated at max rows",
+			config:         configArgs{MaxRows: 2},
+			expectedRows:   2,
+			expectedNotice: "result truncated at 2 rows",
+		},
+		{
+			name:         "Result with max rows rows is not truncated",
+			config:       configArgs{MaxRows: 4},
+			expectedRows: 4,
+		},
+		{
+			name:           "Result truncated at max response bytes",
+			config:         configArgs{MaxResponseBytes: 40},
+			expectedRows:   3,
+			expectedNotice: "result truncated at 3 rows, the response exceeded 40 bytes",
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.Nil(tt, err)
+			defer db.Close()
+
+			// Every row is 12 bytes, a 4 bytes name and an 8 bytes value.
+			mock.ExpectQuery("SELECT name, value FROM test_table").WillReturnRows(sqlmock.NewRows([]string{"name", "value"}).AddRow("aaaa", 1).AddRow("bbbb", 2).AddRow("cccc", 3).AddRow("dddd", 4))
+
+			v := &VerticaDatasource{}
+			queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT name, value FROM test_table", Format: "table"}), &instanceSettings{Db: db, config: tc.config}, nil)
+
+			require.Nil(tt, queryOutput.Error, "Truncated query shouldn't throw any errors")
+			require.Equal(tt, tc.expectedRows, queryOutput.Frames[0].Rows(), "Invalid number of rows")
+			if tc.expectedNotice == "" {
+				require.Empty(tt, queryOutput.Frames[0].Meta.Notices, "Complete result shouldn't have notices")
+			} else {
+				require.Equal(tt, []data.Notice{{Severity: data.NoticeSeverityWarning, Text: tc.expectedNotice}}, queryOutput.Frames[0].Meta.Notices, "Truncated result should have a notice")
+			}
+		})
+	}
+}
+
+func Test_Query_Limits_Final_Frames(t *testing.T) {
+
+	fmt.Println("Query Limits Final Frames Tests")
+
+	fmt.Println("Test Name: Expanded arrays are truncated at max rows")
+	t.Run("Expanded arrays are truncated at max rows", func(tt *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.Nil(tt, err)
+		defer db.Close()
+
+		mockRows := mock.NewRowsWithColumnDefinition(
+			mock.NewColumn("id").OfType("INT", 0),
+			mock.NewColumn("tags").OfType("ARRAY[VARCHAR]", ""),
+		).AddRow(1, `["a","b"]`).AddRow(2, `["c","d"]`)
+		mock.ExpectQuery("SELECT id, tags FROM test_table").WillReturnRows(mockRows)
+
+		v := &VerticaDatasource{}
+		queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT id, tags FROM test_table", Format: "table", ExpandComplexTypes: true}), &instanceSettings{Db: db, config: configArgs{MaxRows: 3}}, nil)
+
+		require.Nil(tt, queryOutput.Error, "Truncated query shouldn't throw any errors")
+		require.Equal(tt, 3, queryOutput.Frames[0].Rows(), "Expanded rows should be limited")
+		require.Equal(tt, []data.Notice{{Severity: data.NoticeSeverityWarning, Text: "result truncated at 3 rows"}}, queryOutput.Frames[0].Meta.Notices, "Truncated result should have a notice")
+	})
+
+	fmt.Println("Test Name: Resampled series are truncated at max rows")
+	t.Run("Resampled series are truncated at max rows", func(tt *testing.T) {
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.Nil(tt, err)
+		defer db.Close()
+
+		mockRows := mock.NewRowsWithColumnDefinition(
+			mock.NewColumn("time").OfType("FLOAT", 0.0),
+			mock.NewColumn("metric").OfType("VARCHAR", ""),
+			mock.NewColumn("value").OfType("FLOAT", 0.0),
+		)
+		for _, metric := range []string{"a", "b", "c"} {
+			mockRows.AddRow(1609459200.0, metric, 1.0).AddRow(1609545600.0, metric, 2.0)
+		}
+		mock.ExpectQuery("SELECT floor(extract(epoch from ts)/86400)*86400 as time, metric, value FROM test_table ORDER BY 1").WillReturnRows(mockRows)
+
+		// Every series is resampled to 25 daily rows.
+		v := &VerticaDatasource{}
+		rawSQL := "SELECT $__timeGroup(ts, '1d', 0), metric, value FROM test_table ORDER BY 1"
+		queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: rawSQL, Format: "time_series_multi"}), &instanceSettings{Db: db, config: configArgs{MaxRows: 30}}, nil)
+
+		require.Nil(tt, queryOutput.Error, "Truncated query shouldn't throw any errors")
+		rows := 0
+		for _, frame := range queryOutput.Frames {
+			rows += frame.Rows()
+			require.Equal(tt, []data.Notice{{Severity: data.NoticeSeverityWarning, Text: "result truncated at 30 rows"}}, frame.Meta.Notices, "Truncated result should have a notice")
+		}
+		require.Equal(tt, 30, rows, "Resampled rows should be limited")
+		require.Len(tt, queryOutput.Frames, 2, "Series past the row limit should be left out")
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Function to resample a wide time series frame over the time range of the query.
+// One row is produced for every interval between the start and the end of the time
+// range. The earliest row of the frame falling in an interval provides the values of
+// that interval, intervals without any row are filled as per the fill mode.
+// The resampled frame is truncated at maxRows rows, when set, and the returned flag
+// tells whether it was.
+func resample(frame *data.Frame, interval time.Duration, timeRange backend.TimeRange, fillMissing *data.FillMissing, maxRows int) (*data.Frame, bool) {
+
+	log.DefaultLogger.Debug("Inside resample.resample Function")
+
+	tsSchema := frame.TimeSeriesSchema()
+	if tsSchema.Type != data.TimeSeriesTypeWide || interval <= 0 {
+		return frame, false
+	}
+
+	start := time.Unix(0, timeRange.From.UnixNano()-timeRange.From.UnixNano()%int64(interval))
+
+	// The rows are visited in time order, whatever the order of the query, rows
+	// without a time are not used.
+	timeField := frame.Fields[tsSchema.TimeIndex]
+	var rows []int
+	for rowIdx := 0; rowIdx < frame.Rows(); rowIdx++ {
+		if _, ok := timeField.ConcreteAt(rowIdx); ok {
+			rows = append(rows, rowIdx)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		ti, _ := timeField.ConcreteAt(rows[i])
+		tj, _ := timeField.ConcreteAt(rows[j])
+		return ti.(time.Time).Before(tj.(time.Time))
+	})
+
+	// Creating the nullable fields of the resampled frame.
+	resampled := data.NewFrame(frame.Name)
+	resampled.RefID = frame.RefID
+	resampled.Meta = frame.Meta
+	for _, field := range frame.Fields {
+		newField := data.NewFieldFromFieldType(field.Type().NullableType(), 0)
+		newField.Name = field.Name
+		newField.Labels = field.Labels
+		newField.Config = field.Config
+		resampled.Fields = append(resampled.Fields, newField)
+	}
+
+	rowIdx := 0
+	for bucket := start; !bucket.After(timeRange.To); bucket = bucket.Add(interval) {
+		if maxRows > 0 && resampled.Rows() >= maxRows {
+			return resampled, true
+		}
+		bucketEnd := bucket.Add(interval)
+
+		// Find the first row of the bucket, skipping rows which are before the bucket.
+		pointIdx := -1
+		for ; rowIdx < len(rows); rowIdx++ {
+			value, _ := timeField.ConcreteAt(rows[rowIdx])
+			t := value.(time.Time)
+			if t.Before(bucket) {
+				continue
+			}
+			if t.Before(bucketEnd) {
+				pointIdx = rows[rowIdx]
+			}
+			break
+		}
+
+		for fieldIdx, field := range resampled.Fields {
+			if fieldIdx == tsSchema.TimeIndex {
+				bucketTime := bucket
+				field.Append(&bucketTime)
+				continue
+			}
+			if pointIdx >= 0 {
+				field.Append(nullableValue(frame.Fields[fieldIdx], pointIdx))
+				continue
+			}
+			field.Append(fillValue(field, fillMissing))
+		}
+
+		// Rows in the remaining part of the bucket are not used.
+		for ; rowIdx < len(rows); rowIdx++ {
+			value, _ := timeField.ConcreteAt(rows[rowIdx])
+			if !value.(time.Time).Before(bucketEnd) {
+				break
+			}
+		}
+	}
+
+	return resampled, false
+}
+
+// Function to get the value of a field at an index as a pointer, as accepted by
+// the nullable fields of the resampled frame.
+func nullableValue(field *data.Field, idx int) interface{} {
+	if field.Nullable() {
+		return field.CopyAt(idx)
+	}
+	switch value := field.CopyAt(idx).(type) {
+	case time.Time:
+		return &value
+	case float64:
+		return &value
+	case int64:
+		return &value
+	case bool:
+		return &value
+	case string:
+		return &value
+	case json.RawMessage:
+		return &value
+	default:
+		log.DefaultLogger.Warn("Unexpected field type while resampling", "field", field.Name)
+		return nilValue(field.Type().NullableType())
+	}
+}
+
+// Function to get the value used to fill a missing bucket of a field.
+func fillValue(field *data.Field, fillMissing *data.FillMissing) interface{} {
+	switch fillMissing.Mode {
+	case data.FillModePrevious:
+		if field.Len() > 0 {
+			return field.CopyAt(field.Len() - 1)
+		}
+	case data.FillModeValue:
+		switch field.Type() {
+		case data.FieldTypeNullableFloat64:
+			value := fillMissing.Value
+			return &value
+		case data.FieldTypeNullableInt64:
+			value := int64(fillMissing.Value)
+			return &value
+		}
+	}
+	return nilValue(field.Type())
+}
+
+// Function to get a typed nil pointer for a nullable field type.
+func nilValue(fieldType data.FieldType) interface{} {
+	return data.NewFieldFromFieldType(fieldType, 1).At(0)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Resample(t *testing.T) {
+
+	fmt.Println("Resample Tests")
+
+	at := func(sec int64) *time.Time {
+		t := time.Unix(sec, 0)
+		return &t
+	}
+	float := func(v float64) *float64 {
+		return &v
+	}
+
+	// Bucket 120 is missing and the row at 30 is before the time range.
+	newFrame := func() *data.Frame {
+		return data.NewFrame("response",
+			data.NewField("time", nil, []*time.Time{at(30), at(60), at(180), at(185)}),
+			data.NewField("value", nil, []*float64{float(0.5), float(1), float(3), float(4)}),
+		)
+	}
+	timeRange := backend.TimeRange{From: time.Unix(60, 0), To: time.Unix(240, 0)}
+
+	tests := []struct {
+		name           string
+		fillMissing    *data.FillMissing
+		expectedValues []*float64
+	}{
+		{
+			name:           "Fill missing buckets with NULL",
+			fillMissing:    &data.FillMissing{Mode: data.FillModeNull},
+			expectedValues: []*float64{float(1), nil, float(3), nil},
+		},
+		{
+			name:           "Fill missing buckets with the previous value",
+			fillMissing:    &data.FillMissing{Mode: data.FillModePrevious},
+			expectedValues: []*float64{float(1), float(1), float(3), float(3)},
+		},
+		{
+			name:           "Fill missing buckets with a constant",
+			fillMissing:    &data.FillMissing{Mode: data.FillModeValue, Value: 7.5},
+			expectedValues: []*float64{float(1), float(7.5), float(3), float(7.5)},
+		},
+	}
+
+	for i, tc := range tests {
+		fmt.Println("Test:", i)
+		fmt.Println("Test:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			resampled, truncated := resample(newFrame(), time.Minute, timeRange, tc.fillMissing, 100)
+			require.False(tt, truncated, "Resampling within the row limit shouldn't truncate the frame")
+
+			require.Equal(tt, len(tc.expectedValues), resampled.Rows(), "One row should be created per interval")
+			for idx, expected := range tc.expectedValues {
+				require.Equal(tt, time.Unix(60+int64(idx)*60, 0).Unix(), resampled.Fields[0].At(idx).(*time.Time).Unix(), "Rows should be aligned to the interval")
+				require.Equal(tt, expected, resampled.Fields[1].At(idx), "Missing buckets should be filled as per the fill mode")
+			}
+		})
+	}
+}
+
+func Test_Resample_Order_And_Limit(t *testing.T) {
+
+	fmt.Println("Resample Order And Limit Tests")
+
+	at := func(sec int64) *time.Time {
+		t := time.Unix(sec, 0)
+		return &t
+	}
+	float := func(v float64) *float64 {
+		return &v
+	}
+	timeRange := backend.TimeRange{From: time.Unix(60, 0), To: time.Unix(240, 0)}
+	fillMissing := &data.FillMissing{Mode: data.FillModeNull}
+
+	fmt.Println("Test Name: Rows in descending time order are resampled")
+	t.Run("Rows in descending time order are resampled", func(tt *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("time", nil, []*time.Time{at(185), at(180), nil, at(60), at(30)}),
+			data.NewField("value", nil, []*float64{float(4), float(3), float(2), float(1), float(0.5)}),
+		)
+		resampled, truncated := resample(frame, time.Minute, timeRange, fillMissing, 100)
+		require.False(tt, truncated, "Resampling within the row limit shouldn't truncate the frame")
+		require.Equal(tt, []*float64{float(1), nil, float(3), nil}, []*float64{
+			resampled.Fields[1].At(0).(*float64), resampled.Fields[1].At(1).(*float64),
+			resampled.Fields[1].At(2).(*float64), resampled.Fields[1].At(3).(*float64),
+		}, "The earliest row of each bucket should be kept whatever the order of the rows")
+	})
+
+	fmt.Println("Test Name: Resampling beyond the row limit is truncated")
+	t.Run("Resampling beyond the row limit is truncated", func(tt *testing.T) {
+		frame := data.NewFrame("response",
+			data.NewField("time", nil, []*time.Time{at(60)}),
+			data.NewField("value", nil, []*float64{float(1)}),
+		)
+		resampled, truncated := resample(frame, time.Second, timeRange, fillMissing, 100)
+		require.True(tt, truncated, "Resampling beyond the row limit should truncate the frame")
+		require.Equal(tt, 100, resampled.Rows(), "The resampled frame should be truncated at the row limit")
+		require.Equal(tt, time.Unix(159, 0).Unix(), resampled.Fields[0].At(99).(*time.Time).Unix(), "The first intervals should be kept")
+
+		resampled, truncated = resample(frame, time.Second, timeRange, fillMissing, 0)
+		require.False(tt, truncated, "Resampling without a row limit shouldn't truncate the frame")
+		require.Equal(tt, 181, resampled.Rows(), "One row should be created per interval")
+	})
+}
+
+func Test_Resample_JSON(t *testing.T) {
+
+	fmt.Println("Resample JSON Tests")
+
+	at := func(sec int64) *time.Time {
+		t := time.Unix(sec, 0)
+		return &t
+	}
+	raw := func(v string) *json.RawMessage {
+		value := json.RawMessage(v)
+		return &value
+	}
+	timeRange := backend.TimeRange{From: time.Unix(60, 0), To: time.Unix(180, 0)}
+
+	tests := []struct {
+		name           string
+		field          *data.Field
+		fillMissing    *data.FillMissing
+		expectedValues []*json.RawMessage
+	}{
+		{
+			name:           "Fill missing buckets of a JSON field with NULL",
+			field:          data.NewField("tags", nil, []json.RawMessage{json.RawMessage(`["a"]`), json.RawMessage(`["b"]`)}),
+			fillMissing:    &data.FillMissing{Mode: data.FillModeNull},
+			expectedValues: []*json.RawMessage{raw(`["a"]`), nil, raw(`["b"]`)},
+		},
+		{
+			name:           "Fill missing buckets of a nullable JSON field with the previous value",
+			field:          data.NewField("tags", nil, []*json.RawMessage{raw(`{"a":1}`), raw(`{"b":2}`)}),
+			fillMissing:    &data.FillMissing{Mode: data.FillModePrevious},
+			expectedValues: []*json.RawMessage{raw(`{"a":1}`), raw(`{"a":1}`), raw(`{"b":2}`)},
+		},
+		{
+			name:           "Fill missing buckets of a JSON field with a constant",
+			field:          data.NewField("tags", nil, []json.RawMessage{json.RawMessage(`[]`), json.RawMessage(`[1]`)}),
+			fillMissing:    &data.FillMissing{Mode: data.FillModeValue, Value: 1},
+			expectedValues: []*json.RawMessage{raw(`[]`), nil, raw(`[1]`)},
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			frame := data.NewFrame("response",
+				data.NewField("time", nil, []*time.Time{at(60), at(180)}),
+				tc.field,
+				data.NewField("value", nil, []float64{1, 3}),
+			)
+			resampled, truncated := resample(frame, time.Minute, timeRange, tc.fillMissing, 0)
+			require.False(tt, truncated, "Resampling without a row limit shouldn't truncate the frame")
+
+			require.Equal(tt, data.FieldTypeNullableJSON, resampled.Fields[1].Type(), "The resampled JSON field should be nullable")
+			require.Equal(tt, len(tc.expectedValues), resampled.Rows(), "One row should be created per interval")
+			for idx, expected := range tc.expectedValues {
+				require.Equal(tt, expected, resampled.Fields[1].At(idx), "Missing buckets should be filled as per the fill mode")
+			}
+		})
+	}
+}
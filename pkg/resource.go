@@ -0,0 +1,224 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// Catalog responses are cached by the browser for this many seconds.
+const catalogCacheMaxAge = 60
+
+// Data type predicates used to filter the columns returned for each column kind
+// of the query builder.
+var columnKindFilters = map[string]string{
+	"time":   "(data_type LIKE 'date%' OR data_type LIKE 'time%' OR data_type LIKE 'timestamp%' OR data_type LIKE 'timetz%' OR data_type LIKE 'timestamptz%' OR data_type LIKE 'interval%')",
+	"metric": "(data_type LIKE 'long varchar%' OR data_type LIKE 'varchar%' OR data_type LIKE 'char%')",
+	"value":  "(data_type LIKE 'double precision%' OR data_type LIKE 'float%' OR data_type LIKE 'real%' OR data_type LIKE 'int%' OR data_type LIKE 'bigint%' OR data_type LIKE 'smallint%' OR data_type LIKE 'tinyint%' OR data_type LIKE 'decimal%' OR data_type LIKE 'numeric%' OR data_type LIKE 'number%' OR data_type LIKE 'money%')",
+}
+
+// newResourceHandler creates the handler serving the catalog browsing endpoints
+// used by the query builder.
+func newResourceHandler(v *VerticaDatasource) backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schemas", v.handleSchemas)
+	mux.HandleFunc("/tables", v.handleTables)
+	mux.HandleFunc("/columns", v.handleColumns)
+	return httpadapter.New(mux)
+}
+
+// handleSchemas returns the list of schemas which contain at least one table.
+func (v *VerticaDatasource) handleSchemas(rw http.ResponseWriter, req *http.Request) {
+
+	log.DefaultLogger.Debug("Inside resource.handleSchemas Function")
+
+	connDB, release, ok := v.catalogDB(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+	result, err := querySchemas(req.Context(), connDB)
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeResourceJSON(rw, result)
+}
+
+// handleTables returns the list of tables of the schema given by the `schema` parameter.
+func (v *VerticaDatasource) handleTables(rw http.ResponseWriter, req *http.Request) {
+
+	log.DefaultLogger.Debug("Inside resource.handleTables Function")
+
+	schema := req.URL.Query().Get("schema")
+	if schema == "" {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("missing schema parameter"))
+		return
+	}
+	connDB, release, ok := v.catalogDB(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+	result, err := queryTables(req.Context(), connDB, schema)
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeResourceJSON(rw, result)
+}
+
+// handleColumns returns the columns of the table given by the `schema` and `table`
+// parameters, optionally restricted to a column kind (time, metric or value).
+func (v *VerticaDatasource) handleColumns(rw http.ResponseWriter, req *http.Request) {
+
+	log.DefaultLogger.Debug("Inside resource.handleColumns Function")
+
+	params := req.URL.Query()
+	if params.Get("table") == "" {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("missing table parameter"))
+		return
+	}
+	kind := params.Get("kind")
+	if _, ok := columnKindFilters[kind]; kind != "" && !ok {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("unknown column kind %q", kind))
+		return
+	}
+	connDB, release, ok := v.catalogDB(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+	result, err := queryColumns(req.Context(), connDB, params.Get("schema"), params.Get("table"), kind, params.Get("timeColumn"))
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	writeResourceJSON(rw, result)
+}
+
+// Function to get the connection pool browsing the catalog for a request, which is the
+// pool of the Grafana user when the OAuth identity is forwarded, so that the user only
+// sees the objects it is granted. The error is written to the response when the pool
+// is not available. The returned function releases the pool.
+func (v *VerticaDatasource) catalogDB(rw http.ResponseWriter, req *http.Request) (*sql.DB, func(), bool) {
+	pluginContext := backend.PluginConfigFromContext(req.Context())
+	instance, err := v.getInstanceSettings(pluginContext)
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return nil, nil, false
+	}
+	instance, release, err := instance.forUser(pluginContext, req.Header.Get(backend.OAuthIdentityTokenHeaderName))
+	if err != nil {
+		writeResourceError(rw, http.StatusUnauthorized, err)
+		return nil, nil, false
+	}
+	return instance.Db, release, true
+}
+
+// Function to fetch the schemas from the Vertica catalog.
+func querySchemas(ctx context.Context, connDB *sql.DB) ([]string, error) {
+	return queryCatalog(ctx, connDB, "SELECT DISTINCT table_schema FROM v_catalog.tables ORDER BY table_schema")
+}
+
+// Function to fetch the tables of a schema from the Vertica catalog.
+func queryTables(ctx context.Context, connDB *sql.DB, schema string) ([]string, error) {
+	return queryCatalog(ctx, connDB, "SELECT table_name FROM v_catalog.tables WHERE table_schema = ? ORDER BY table_name", unquoteIdentifier(schema))
+}
+
+// Function to fetch the columns of a table from the Vertica catalog. The table may be
+// schema qualified, in which case the schema argument is ignored.
+func queryColumns(ctx context.Context, connDB *sql.DB, schema string, table string, kind string, timeColumn string) ([]string, error) {
+	if parts := splitQualifiedIdentifier(table); len(parts) == 2 {
+		schema, table = parts[0], parts[1]
+	}
+	query := "SELECT column_name FROM v_catalog.columns WHERE table_schema = ? AND table_name = ?"
+	args := []interface{}{unquoteIdentifier(schema), unquoteIdentifier(table)}
+	if kind != "" {
+		query += " AND " + columnKindFilters[kind]
+	}
+	if kind == "value" && timeColumn != "" {
+		query += " AND column_name <> ?"
+		args = append(args, unquoteIdentifier(timeColumn))
+	}
+	query += " ORDER BY column_name"
+	return queryCatalog(ctx, connDB, query, args...)
+}
+
+// Function to run a catalog query returning a single string column.
+func queryCatalog(ctx context.Context, connDB *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := connDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.DefaultLogger.Error("Error while querying the catalog: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+	return result, rows.Err()
+}
+
+func writeResourceJSON(rw http.ResponseWriter, result []string) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		writeResourceError(rw, http.StatusInternalServerError, err)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", catalogCacheMaxAge))
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(body)
+}
+
+func writeResourceError(rw http.ResponseWriter, status int, err error) {
+	log.DefaultLogger.Error("Error while handling the resource request: " + err.Error())
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(body)
+}
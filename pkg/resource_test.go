@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CatalogQueries(t *testing.T) {
+
+	fmt.Println("Catalog Resource Tests")
+
+	tests := []struct {
+		name          string
+		run           func(db *sql.DB) ([]string, error)
+		expectedQuery string
+		expectedArgs  []driver.Value
+		expected      []string
+	}{
+		{
+			name: "Schemas",
+			run: func(db *sql.DB) ([]string, error) {
+				return querySchemas(context.Background(), db)
+			},
+			expectedQuery: "SELECT DISTINCT table_schema FROM v_catalog.tables ORDER BY table_schema",
+			expected:      []string{"public", "store"},
+		},
+		{
+			name: "Tables of a quoted schema",
+			run: func(db *sql.DB) ([]string, error) {
+				return queryTables(context.Background(), db, `"My""Schema"`)
+			},
+			expectedQuery: "SELECT table_name FROM v_catalog.tables WHERE table_schema = ? ORDER BY table_name",
+			expectedArgs:  []driver.Value{`My"Schema`},
+			expected:      []string{"orders"},
+		},
+		{
+			name: "Time columns of a schema qualified table",
+			run: func(db *sql.DB) ([]string, error) {
+				return queryColumns(context.Background(), db, "public", "store.orders", "time", "")
+			},
+			expectedQuery: "SELECT column_name FROM v_catalog.columns WHERE table_schema = ? AND table_name = ? AND " + columnKindFilters["time"] + " ORDER BY column_name",
+			expectedArgs:  []driver.Value{"store", "orders"},
+			expected:      []string{"created_at"},
+		},
+		{
+			name: "Value columns excluding the time column",
+			run: func(db *sql.DB) ([]string, error) {
+				return queryColumns(context.Background(), db, "public", `"a.b"`, "value", "created_at")
+			},
+			expectedQuery: "SELECT column_name FROM v_catalog.columns WHERE table_schema = ? AND table_name = ? AND " + columnKindFilters["value"] + " AND column_name <> ? ORDER BY column_name",
+			expectedArgs:  []driver.Value{"public", "a.b", "created_at"},
+			expected:      []string{"amount", "qty"},
+		},
+	}
+
+	for i, tc := range tests {
+		fmt.Println("Test:", i)
+		fmt.Println("Test:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.Nil(tt, err)
+			defer db.Close()
+
+			rows := sqlmock.NewRows([]string{"name"})
+			for _, name := range tc.expected {
+				rows.AddRow(name)
+			}
+			expectation := mock.ExpectQuery(regexp.QuoteMeta(tc.expectedQuery))
+			if len(tc.expectedArgs) > 0 {
+				expectation.WithArgs(tc.expectedArgs...)
+			}
+			expectation.WillReturnRows(rows)
+
+			result, err := tc.run(db)
+
+			require.Nil(tt, err, "Catalog query shouldn't throw any errors")
+			require.Equal(tt, tc.expected, result, "Catalog query should return the scanned names")
+			require.Nil(tt, mock.ExpectationsWereMet(), "Catalog query should match the expected SQL")
+		})
+	}
+}
+
+func Test_ColumnsResourceValidation(t *testing.T) {
+
+	fmt.Println("Columns Resource Validation Tests")
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+	}{
+		{
+			name:           "Missing table parameter",
+			url:            "/columns?schema=public",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Unknown column kind",
+			url:            "/columns?schema=public&table=orders&kind=blob",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	v := &VerticaDatasource{}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(tt *testing.T) {
+			rw := httptest.NewRecorder()
+			v.handleColumns(rw, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			require.Equal(tt, tc.expectedStatus, rw.Code, "Invalid parameters should be rejected")
+		})
+	}
+}
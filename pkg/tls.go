@@ -0,0 +1,196 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	vertica "github.com/vertica/vertica-sql-go"
+)
+
+// Names of the secure settings holding the PEM encoded TLS material of the datasource.
+const (
+	secureTLSCACert     = "tlsCACert"
+	secureTLSClientCert = "tlsClientCert"
+	secureTLSClientKey  = "tlsClientKey"
+)
+
+// tlsState records the last TLS handshake of the connections of a datasource, so
+// that the health check can report the negotiated TLS version and server certificate.
+type tlsState struct {
+	mu    sync.Mutex
+	state *tls.ConnectionState
+}
+
+// Function to record the state of a TLS handshake, called once the handshake is verified.
+func (s *tlsState) record(state tls.ConnectionState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = &state
+	return nil
+}
+
+// Function to describe the last TLS handshake, empty when there is none.
+func (s *tlsState) describe() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return ""
+	}
+	description := tls.VersionName(s.state.Version)
+	if len(s.state.PeerCertificates) > 0 {
+		description += fmt.Sprintf(" with the server certificate %s", s.state.PeerCertificates[0].Subject)
+	}
+	return description
+}
+
+// Function to build the TLS configuration of the connections of the datasource for the
+// server and server-strict SSL modes. The server mode encrypts the connection without
+// verifying the server certificate, as the vertica driver does. The server-strict mode
+// verifies it against the CA certificate of the settings, or the system ones, and the
+// server name of the settings, or the hosts the connection may dial. A client
+// certificate is sent when set. There is no configuration, and the SSL mode is left to
+// the driver, when none of the TLS settings is set.
+func newTLSConfig(config configArgs, secureData map[string]string, state *tlsState) (*tls.Config, error) {
+	caCert := secureData[secureTLSCACert]
+	clientCert := secureData[secureTLSClientCert]
+	clientKey := secureData[secureTLSClientKey]
+
+	custom := caCert != "" || clientCert != "" || clientKey != "" || config.TLSServerName != ""
+	if config.TLSMode != "server" && config.TLSMode != "server-strict" {
+		if custom {
+			return nil, fmt.Errorf("the TLS settings need the server or server-strict SSL mode")
+		}
+		return nil, nil
+	}
+	if !custom {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         config.TLSServerName,
+		InsecureSkipVerify: config.TLSMode == "server",
+		VerifyConnection:   state.record,
+	}
+	if caCert != "" {
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(caCert)) {
+			return nil, fmt.Errorf("invalid CA certificate, no PEM encoded certificate found")
+		}
+	}
+	if clientCert != "" || clientKey != "" {
+		certificate, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	if config.TLSMode == "server-strict" && tlsConfig.ServerName == "" {
+		// The driver uses a registered configuration as it is, so the server name can't be
+		// the host it dials, which is the primary, a backup node or a load balancing target.
+		// The certificate is verified here instead, against any of the configured hosts.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = verifyHosts(tlsConfig.RootCAs, connectionHosts(config), state)
+	}
+	return tlsConfig, nil
+}
+
+// Function to list the hosts a connection of the datasource may dial, the host of the
+// URL followed by the backup server nodes.
+func connectionHosts(config configArgs) []string {
+	var hosts []string
+	for _, address := range append([]string{config.URL}, strings.Split(config.BackupServerNode, ",")...) {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// Function to verify the server certificate of a handshake against the CA certificates
+// and any of the hosts, before recording the handshake.
+func verifyHosts(roots *x509.CertPool, hosts []string, state *tlsState) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("the server sent no certificate")
+		}
+		intermediates := x509.NewCertPool()
+		for _, certificate := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(certificate)
+		}
+		if _, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+			return err
+		}
+		var err error
+		for _, host := range hosts {
+			if err = cs.PeerCertificates[0].VerifyHostname(host); err == nil {
+				return state.record(cs)
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("no host to verify the server certificate against")
+		}
+		return err
+	}
+}
+
+// Function to register the TLS configuration of the datasource with the vertica driver.
+// It returns the name of the configuration, used as the tlsmode of the connection URL,
+// and is a no-op returning the SSL mode of the settings when there is no configuration.
+func registerTLSConfig(settings backend.DataSourceInstanceSettings, config configArgs, state *tlsState) (string, error) {
+	tlsConfig, err := newTLSConfig(config, settings.DecryptedSecureJSONData, state)
+	if err != nil || tlsConfig == nil {
+		return config.TLSMode, err
+	}
+	// The configuration of a datasource is replaced when its settings are updated.
+	name := fmt.Sprintf("grafana-%s-%d", settings.UID, settings.ID)
+	if err := vertica.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", fmt.Errorf("failed to register the TLS configuration: %w", err)
+	}
+	return name, nil
+}
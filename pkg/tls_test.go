@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate is a certificate and its key, PEM encoded.
+type testCertificate struct {
+	cert, key   string
+	certificate *x509.Certificate
+	privateKey  *ecdsa.PrivateKey
+}
+
+// Function to create a certificate signed by the parent, self signed when the parent is nil.
+func newTestCertificate(t *testing.T, commonName string, parent *testCertificate) testCertificate {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err, "Key generation shouldn't throw any errors")
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Test"}},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, privateKey
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.certificate, parent.privateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &privateKey.PublicKey, signerKey)
+	require.Nil(t, err, "Certificate creation shouldn't throw any errors")
+	certificate, err := x509.ParseCertificate(der)
+	require.Nil(t, err, "Certificate parsing shouldn't throw any errors")
+	keyDer, err := x509.MarshalECPrivateKey(privateKey)
+	require.Nil(t, err, "Key encoding shouldn't throw any errors")
+
+	return testCertificate{
+		cert:        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		key:         string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+		certificate: certificate,
+		privateKey:  privateKey,
+	}
+}
+
+// Function to run a TLS handshake between a client using the configuration and a server
+// using the certificate. The server requires a client certificate signed by the CA.
+func handshake(clientConfig *tls.Config, server testCertificate, ca testCertificate) error {
+	serverCertificate, err := tls.X509KeyPair([]byte(server.cert), []byte(server.key))
+	if err != nil {
+		return err
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.certificate)
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		tlsServer := tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{serverCertificate},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+		})
+		serverErr <- tlsServer.Handshake()
+		serverConn.Close()
+	}()
+
+	clientErr := tls.Client(clientConn, clientConfig).Handshake()
+	clientConn.Close()
+	if err := <-serverErr; clientErr == nil {
+		return err
+	}
+	return clientErr
+}
+
+func Test_NewTLSConfig(t *testing.T) {
+
+	fmt.Println("TLS Configuration Tests")
+
+	ca := newTestCertificate(t, "Test CA", nil)
+	server := newTestCertificate(t, "vertica.example.com", &ca)
+	client := newTestCertificate(t, "grafana", &ca)
+	otherCA := newTestCertificate(t, "Other CA", nil)
+
+	tests := []struct {
+		name            string
+		config          configArgs
+		secureData      map[string]string
+		expectingConfig bool
+		expectingErr    error
+		handshakeErr    bool
+	}{
+		{
+			name:   "No TLS",
+			config: configArgs{TLSMode: "none", URL: "vertica.example.com:5433"},
+		},
+		{
+			name:         "TLS settings without SSL mode",
+			config:       configArgs{TLSMode: "none", URL: "vertica.example.com:5433"},
+			secureData:   map[string]string{secureTLSCACert: ca.cert},
+			expectingErr: fmt.Errorf("the TLS settings need the server or server-strict SSL mode"),
+		},
+		{
+			name:            "Server strict mode with CA and client certificate",
+			config:          configArgs{TLSMode: "server-strict", URL: "vertica.example.com:5433"},
+			secureData:      map[string]string{secureTLSCACert: ca.cert, secureTLSClientCert: client.cert, secureTLSClientKey: client.key},
+			expectingConfig: true,
+		},
+		{
+			name:            "Server strict mode with server name override",
+			config:          configArgs{TLSMode: "server-strict", URL: "10.0.0.1:5433", TLSServerName: "vertica.example.com"},
+			secureData:      map[string]string{secureTLSCACert: ca.cert, secureTLSClientCert: client.cert, secureTLSClientKey: client.key},
+			expectingConfig: true,
+		},
+		{
+			name:            "Server strict mode with wrong server name",
+			config:          configArgs{TLSMode: "server-strict", URL: "10.0.0.1:5433"},
+			secureData:      map[string]string{secureTLSCACert: ca.cert, secureTLSClientCert: client.cert, secureTLSClientKey: client.key},
+			expectingConfig: true,
+			handshakeErr:    true,
+		},
+		{
+			name:            "Server strict mode with other CA",
+			config:          configArgs{TLSMode: "server-strict", URL: "vertica.example.com:5433"},
+			secureData:      map[string]string{secureTLSCACert: otherCA.cert, secureTLSClientCert: client.cert, secureTLSClientKey: client.key},
+			expectingConfig: true,
+			handshakeErr:    true,
+		},
+		{
+			name:            "Server mode without verification",
+			config:          configArgs{TLSMode: "server", URL: "10.0.0.1:5433"},
+			secureData:      map[string]string{secureTLSClientCert: client.cert, secureTLSClientKey: client.key},
+			expectingConfig: true,
+		},
+		{
+			name:   "Server mode without TLS settings",
+			config: configArgs{TLSMode: "server", URL: "vertica.example.com:5433"},
+		},
+		{
+			name:   "Server strict mode without TLS settings",
+			config: configArgs{TLSMode: "server-strict", URL: "vertica.example.com:5433"},
+		},
+		{
+			name:            "Server strict mode with a backup server node",
+			config:          configArgs{TLSMode: "server-strict", URL: "10.0.0.1:5433", BackupServerNode: "10.0.0.2:5433, vertica.example.com:5433"},
+			secureData:      map[string]string{secureTLSCACert: ca.cert, secureTLSClientCert: client.cert, secureTLSClientKey: client.key},
+			expectingConfig: true,
+		},
+		{
+			name:            "Server strict mode with other backup server nodes",
+			config:          configArgs{TLSMode: "server-strict", URL: "10.0.0.1:5433", BackupServerNode: "10.0.0.2:5433"},
+			secureData:      map[string]string{secureTLSCACert: ca.cert, secureTLSClientCert: client.cert, secureTLSClientKey: client.key},
+			expectingConfig: true,
+			handshakeErr:    true,
+		},
+		{
+			name:         "Invalid CA certificate",
+			config:       configArgs{TLSMode: "server-strict", URL: "vertica.example.com:5433"},
+			secureData:   map[string]string{secureTLSCACert: "not a certificate"},
+			expectingErr: fmt.Errorf("invalid CA certificate, no PEM encoded certificate found"),
+		},
+		{
+			name:         "Client certificate without key",
+			config:       configArgs{TLSMode: "server-strict", URL: "vertica.example.com:5433"},
+			secureData:   map[string]string{secureTLSClientCert: client.cert},
+			expectingErr: fmt.Errorf("invalid client certificate: tls: failed to find any PEM data in key input"),
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			state := &tlsState{}
+			tlsConfig, err := newTLSConfig(tc.config, tc.secureData, state)
+
+			if tc.expectingErr != nil {
+				require.EqualError(tt, err, tc.expectingErr.Error(), "Invalid error")
+				return
+			}
+			require.Nil(tt, err, "Valid TLS settings shouldn't throw any errors")
+			if !tc.expectingConfig {
+				require.Nil(tt, tlsConfig, "No TLS configuration should be built")
+				return
+			}
+			require.NotNil(tt, tlsConfig, "A TLS configuration should be built")
+
+			err = handshake(tlsConfig, server, ca)
+			if tc.handshakeErr {
+				require.NotNil(tt, err, "The TLS handshake should fail")
+				return
+			}
+			require.Nil(tt, err, "The TLS handshake shouldn't throw any errors")
+			require.Equal(tt, "TLS 1.3 with the server certificate CN=vertica.example.com,O=Test", state.describe(), "Invalid TLS description")
+		})
+	}
+}
+
+func Test_CheckHealth_TLS(t *testing.T) {
+
+	fmt.Println("TLS Check Health Tests")
+
+	state := &tlsState{}
+	server := newTestCertificate(t, "vertica.example.com", nil)
+	require.Nil(t, state.record(tls.ConnectionState{Version: tls.VersionTLS12, PeerCertificates: []*x509.Certificate{server.certificate}}))
+
+	v := &VerticaDatasource{im: datasource.NewInstanceManager(func(_ context.Context, settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			return nil, err
+		}
+		mock.ExpectQuery("SELECT version()").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("Vertica Analytic Database v10.1.0-0"))
+		return &instanceSettings{Db: db, Name: settings.Name, tlsState: state}, nil
+	})}
+
+	healthStatus, err := v.CheckHealth(context.Background(), &backend.CheckHealthRequest{PluginContext: getCredentials(configArgs{TLSMode: "server-strict"})})
+	require.Nil(t, err, "Check health shouldn't throw any errors")
+	require.Equal(t, backend.HealthStatusOk, healthStatus.Status, "Health Status should be Ok")
+	require.Equal(t, "Successfully connected to Vertica Analytic Database v10.1.0-0 using TLS 1.2 with the server certificate CN=vertica.example.com,O=Test", healthStatus.Message, "Health Message should be same")
+}
@@ -0,0 +1,248 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// sqlToken is a macro call found in a query by the tokenizer.
+type sqlToken struct {
+	// name is the name of the macro, without the leading $.
+	name string
+
+	// args is the raw text between the parentheses of the macro, if any.
+	args string
+
+	// start and end are the offsets of the macro call in the query.
+	start, end int
+}
+
+// Function to check whether a byte may be part of an identifier.
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// Function to get the line and column of an offset of the query, both starting at 1.
+func position(sql string, offset int) (int, int) {
+	line := strings.Count(sql[:offset], "\n") + 1
+	lineStart := strings.LastIndex(sql[:offset], "\n") + 1
+	return line, utf8.RuneCountInString(sql[lineStart:offset]) + 1
+}
+
+// macroError is an error of the macros of a query, located at an offset of the query.
+type macroError struct {
+	offset int
+	msg    string
+}
+
+func (err *macroError) Error() string {
+	return err.msg
+}
+
+// Function to create an error located at an offset of the query.
+func newMacroError(offset int, format string, args ...interface{}) *macroError {
+	return &macroError{offset: offset, msg: fmt.Sprintf(format, args...)}
+}
+
+// Function to add the line and column of a macro error in the query to its message.
+// Other errors are returned as is.
+func positionedError(sql string, err error) error {
+	var macroErr *macroError
+	if !errors.As(err, &macroErr) {
+		return err
+	}
+	line, col := position(sql, macroErr.offset)
+	return fmt.Errorf("%s at line %d col %d", macroErr.msg, line, col)
+}
+
+// Function to get the end offset of the string literal, quoted identifier or comment
+// starting at offset i of the query. It returns -1 when there is none at i.
+// Single quoted strings, with backslash escapes when prefixed by E, dollar quoted strings, double
+// quoted identifiers, line comments and block comments are supported.
+func skipLiteral(sql string, i int) (int, error) {
+	switch {
+	case sql[i] == '\'':
+		escapes := i > 0 && (sql[i-1] == 'e' || sql[i-1] == 'E') && (i == 1 || !isIdentifierChar(sql[i-2]))
+		for j := i + 1; j < len(sql); j++ {
+			if escapes && sql[j] == '\\' {
+				j++
+			} else if sql[j] == '\'' {
+				if j+1 < len(sql) && sql[j+1] == '\'' {
+					j++
+					continue
+				}
+				return j + 1, nil
+			}
+		}
+		return 0, newMacroError(i, "unterminated string literal")
+	case sql[i] == '"':
+		for j := i + 1; j < len(sql); j++ {
+			if sql[j] == '"' {
+				if j+1 < len(sql) && sql[j+1] == '"' {
+					j++
+					continue
+				}
+				return j + 1, nil
+			}
+		}
+		return 0, newMacroError(i, "unterminated quoted identifier")
+	case strings.HasPrefix(sql[i:], "--"):
+		if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+			return i + end + 1, nil
+		}
+		return len(sql), nil
+	case strings.HasPrefix(sql[i:], "/*"):
+		if end := strings.Index(sql[i+2:], "*/"); end >= 0 {
+			return i + 2 + end + 2, nil
+		}
+		return 0, newMacroError(i, "unterminated block comment")
+	case sql[i] == '$' && (i == 0 || !isIdentifierChar(sql[i-1])):
+		tagEnd := i + 1
+		for tagEnd < len(sql) && sql[tagEnd] != '$' && isIdentifierChar(sql[tagEnd]) {
+			tagEnd++
+		}
+		if tagEnd >= len(sql) || sql[tagEnd] != '$' || (tagEnd > i+1 && '0' <= sql[i+1] && sql[i+1] <= '9') {
+			return -1, nil
+		}
+		delimiter := sql[i : tagEnd+1]
+		if end := strings.Index(sql[tagEnd+1:], delimiter); end >= 0 {
+			return tagEnd + 1 + end + len(delimiter), nil
+		}
+		return 0, newMacroError(i, "unterminated dollar-quoted string")
+	}
+	return -1, nil
+}
+
+// Function to find the macro calls of a query, skipping the string literals, quoted
+// identifiers and comments. The arguments of a macro are the text between the
+// parentheses immediately following its name, nested parentheses included.
+func tokenizeMacros(sql string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	for i := 0; i < len(sql); {
+		end, err := skipLiteral(sql, i)
+		if err != nil {
+			return nil, err
+		}
+		if end >= 0 {
+			i = end
+			continue
+		}
+		if !strings.HasPrefix(sql[i:], "$__") || (i > 0 && isIdentifierChar(sql[i-1])) {
+			i++
+			continue
+		}
+
+		token := sqlToken{start: i}
+		nameEnd := i + 1
+		for nameEnd < len(sql) && isIdentifierChar(sql[nameEnd]) && sql[nameEnd] != '$' {
+			nameEnd++
+		}
+		token.name = sql[i+1 : nameEnd]
+		token.end = nameEnd
+
+		if nameEnd < len(sql) && sql[nameEnd] == '(' {
+			closing, err := findClosingParenthesis(sql, nameEnd)
+			if err != nil {
+				return nil, err
+			}
+			token.args = sql[nameEnd+1 : closing]
+			token.end = closing + 1
+		}
+		tokens = append(tokens, token)
+		i = token.end
+	}
+	return tokens, nil
+}
+
+// Function to find the parenthesis closing the one at offset open of the query.
+func findClosingParenthesis(sql string, open int) (int, error) {
+	depth := 0
+	for i := open; i < len(sql); {
+		end, err := skipLiteral(sql, i)
+		if err != nil {
+			return 0, err
+		}
+		if end >= 0 {
+			i = end
+			continue
+		}
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+		i++
+	}
+	return 0, newMacroError(open, "unterminated macro argument")
+}
+
+// Function to split the arguments of a macro on the commas which are not nested in
+// parentheses, string literals, quoted identifiers or comments. It also returns the
+// offsets of the arguments in args.
+func splitArguments(args string) ([]string, []int, error) {
+	var result []string
+	var starts []int
+	depth, start := 0, 0
+	for i := 0; i < len(args); {
+		end, err := skipLiteral(args, i)
+		if err != nil {
+			return nil, nil, err
+		}
+		if end >= 0 {
+			i = end
+			continue
+		}
+		switch args[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				result = append(result, args[start:i])
+				starts = append(starts, start)
+				start = i + 1
+			}
+		}
+		i++
+	}
+	return append(result, args[start:]), append(starts, start), nil
+}
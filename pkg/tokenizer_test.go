@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Macros_Tokenizer(t *testing.T) {
+
+	fmt.Println("Macros Tokenizer Tests")
+
+	tests := []struct {
+		name          string
+		rawSQL        string
+		expectedQuery string
+		expectedErr   string
+	}{
+		{
+			name:          "Macro in a string literal is not expanded",
+			rawSQL:        "SELECT '$__timeFrom()', $__timeFrom()",
+			expectedQuery: "SELECT '$__timeFrom()', '2021-01-01T12:00:00Z'",
+		},
+		{
+			name:          "Macro in an escaped string literal is not expanded",
+			rawSQL:        `SELECT E'it\'s $__timeTo()', 'it''s $__timeTo()', $__timeTo()`,
+			expectedQuery: `SELECT E'it\'s $__timeTo()', 'it''s $__timeTo()', '2021-01-25T12:00:00Z'`,
+		},
+		{
+			name:          "Macro in a dollar quoted string is not expanded",
+			rawSQL:        "SELECT $$ $__timeTo() $$, $tag$ $__timeTo() $tag$ FROM t WHERE $__unixEpochFilter(ts)",
+			expectedQuery: "SELECT $$ $__timeTo() $$, $tag$ $__timeTo() $tag$ FROM t WHERE ts >= 1609502400 AND ts <= 1611576000",
+		},
+		{
+			name:          "Macro in a quoted identifier is not expanded",
+			rawSQL:        `SELECT "$__time(x)" FROM t`,
+			expectedQuery: `SELECT "$__time(x)" FROM t`,
+		},
+		{
+			name:          "Macros in comments are not expanded",
+			rawSQL:        "SELECT 1 -- $__time(\n/* $__timeGroup( */ FROM t",
+			expectedQuery: "SELECT 1 -- $__time(\n/* $__timeGroup( */ FROM t",
+		},
+		{
+			name:          "Macro at the end of the query without arguments",
+			rawSQL:        "SELECT * FROM t WHERE ts > $__unixEpochFrom",
+			expectedQuery: "SELECT * FROM t WHERE ts > 1609502400",
+		},
+		{
+			name:          "Macro arguments with nested parentheses, literals and commas",
+			rawSQL:        "SELECT $__timeGroup(coalesce(ts, ')'), '5m', 0) FROM t",
+			expectedQuery: "SELECT floor(extract(epoch from coalesce(ts, ')'))/300)*300 as time FROM t",
+		},
+		{
+			name:          "Query value macros in literals and comments are not expanded",
+			rawSQL:        "SELECT $__interval_ms, \"$__interval\" -- $__interval\n, $$ $__maxDataPoints $$",
+			expectedQuery: "SELECT 0, \"$__interval\" -- $__interval\n, $$ $__maxDataPoints $$",
+		},
+		{
+			name:        "Unbalanced parentheses",
+			rawSQL:      "SELECT *\nFROM t\nWHERE $__timeFilter(coalesce(ts, now()",
+			expectedErr: "unterminated macro argument at line 3 col 20",
+		},
+		{
+			name:        "Unterminated string literal",
+			rawSQL:      "SELECT $__time(ts)\nFROM t WHERE a = 'b",
+			expectedErr: "unterminated string literal at line 2 col 18",
+		},
+		{
+			name:        "Unterminated block comment",
+			rawSQL:      "SELECT 1 /* $__time(ts)",
+			expectedErr: "unterminated block comment at line 1 col 10",
+		},
+		{
+			name:        "Unterminated quoted identifier",
+			rawSQL:      `SELECT "ts`,
+			expectedErr: "unterminated quoted identifier at line 1 col 8",
+		},
+		{
+			name:        "Macro error is positioned",
+			rawSQL:      "SELECT\n  $__test(ts)",
+			expectedErr: "undefined macro: $____test at line 2 col 3",
+		},
+		{
+			name:        "Error of a macro nested in an argument is positioned in the query",
+			rawSQL:      "SELECT $__time($__timeFrom(x))",
+			expectedErr: "unknown time zone x at line 1 col 16",
+		},
+		{
+			name:        "Error of a nested macro on another line is positioned in the query",
+			rawSQL:      "SELECT 1,\n  $__timeGroup(ts, $__interval(1))",
+			expectedErr: "macro __interval should have no arguments at line 2 col 20",
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			require.NotPanics(tt, func() {
+				querySQL, _, err := sanitizeAndInterpolateMacros(tc.rawSQL, getDataQuery(queryModel{RawSQL: tc.rawSQL}), &instanceSettings{})
+				if tc.expectedErr != "" {
+					require.NotNil(tt, err, "Should set error if failed")
+					require.Equal(tt, tc.expectedErr, err.Error(), "Error should give the position")
+					return
+				}
+				require.Nil(tt, err, "Correct query shouldn't throw any errors")
+				require.Equal(tt, tc.expectedQuery, querySQL, "Invalid interpolated query")
+			})
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+// Copyright (c) 2019 Micro Focus or one of its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Largest number of significant digits a float64 holds without losing precision.
+const float64Digits = 15
+
+// Precision reported by the driver for the NUMERIC columns with an unknown type modifier.
+const unknownNumericPrecision = 1024
+
+// Number of seconds of the year and month units of the intervals, a month is 30 days as in the duration units.
+const (
+	secondsPerDay   = float64(24 * time.Hour / time.Second)
+	secondsPerMonth = 30 * secondsPerDay
+	secondsPerYear  = 12 * secondsPerMonth
+)
+
+// databaseColumnType is the part of the sql.ColumnType used by the type mapping.
+type databaseColumnType interface {
+	DatabaseTypeName() string
+	DecimalSize() (precision, scale int64, ok bool)
+}
+
+// Function to map the Vertica type of a column to the type of its frame field, along
+// with the field config of the type. NUMERIC columns are mapped to floats, as the driver
+// scans them as float64, unless the ones wider than a float64 are cast to VARCHAR in the
+// query to keep all their digits.
+func mapColumnType(columnType databaseColumnType) (string, *data.FieldConfig) {
+	typeName := strings.ToUpper(columnType.DatabaseTypeName())
+
+	if isComplexType(typeName) {
+		return "JSON", nil
+	}
+
+	if strings.HasPrefix(typeName, "INTERVAL") {
+		return "DURATION", &data.FieldConfig{Unit: "s"}
+	}
+
+	switch typeName {
+	case "INTEGER", "INT":
+		return "INTEGER", nil
+	case "NUMERIC", "DECIMAL":
+		precision, scale, ok := columnType.DecimalSize()
+		if !ok {
+			return "FLOAT", nil
+		}
+		// The driver reports a precision of 1024 when the type modifier of the column is
+		// unknown, e.g. for expressions, the scale is then meaningless.
+		config := &data.FieldConfig{}
+		if precision < unknownNumericPrecision {
+			decimals := uint16(scale)
+			config.Decimals = &decimals
+		}
+		return "FLOAT", config
+	case "REAL", "DOUBLE", "FLOAT":
+		return "FLOAT", nil
+	case "UUID":
+		filterable := true
+		return "STRING", &data.FieldConfig{Filterable: &filterable}
+	case "NULL", "TEXT", "BLOB", "VARCHAR", "LONG VARCHAR", "CHAR", "VARBINARY", "LONG VARBINARY", "BINARY":
+		return "STRING", nil
+	case "DATE", "TIME", "TIMETZ", "TIMESTAMP", "TIMESTAMPTZ":
+		return "TIME", nil
+	case "BOOL", "BOOLEAN":
+		return "BOOLEAN", nil
+	default:
+		return "UNKNOWN", nil
+	}
+}
+
+// Function to convert an interval returned by Vertica to seconds. The range is the
+// one of the column type (e.g. DAY TO SECOND), it gives the unit of the fields of
+// values like "1 02:03:04.5", "03:04" or "2-06".
+func parseInterval(value string, intervalRange string) (float64, error) {
+	value = strings.TrimSpace(value)
+	sign := 1.0
+	if strings.HasPrefix(value, "-") {
+		sign, value = -1, value[1:]
+	} else if strings.HasPrefix(value, "+") {
+		value = value[1:]
+	}
+	if value == "" {
+		return 0, fmt.Errorf("invalid interval %q", value)
+	}
+
+	leadingUnit := strings.Fields(strings.ToUpper(intervalRange) + " DAY")[0]
+
+	// Year to month intervals are returned as years-months.
+	if leadingUnit == "YEAR" || leadingUnit == "MONTH" {
+		parts := strings.SplitN(value, "-", 2)
+		first, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q", value)
+		}
+		if len(parts) == 1 {
+			if leadingUnit == "MONTH" {
+				return sign * first * secondsPerMonth, nil
+			}
+			return sign * first * secondsPerYear, nil
+		}
+		months, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q", value)
+		}
+		return sign * (first*secondsPerYear + months*secondsPerMonth), nil
+	}
+
+	// Day to second intervals are returned as days hh:mm:ss, the fields of the
+	// time part start at the leading unit of the range, or at hours after the days.
+	units := map[string][]float64{
+		"DAY":    {secondsPerDay, 3600, 60, 1},
+		"HOUR":   {3600, 60, 1},
+		"MINUTE": {60, 1},
+		"SECOND": {1},
+	}[leadingUnit]
+	if units == nil {
+		return 0, fmt.Errorf("unsupported interval range %q", intervalRange)
+	}
+
+	seconds := 0.0
+	if fields := strings.Fields(value); len(fields) == 2 {
+		days, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q", value)
+		}
+		seconds, value, units = days*secondsPerDay, fields[1], []float64{3600, 60, 1}
+	} else if len(fields) != 1 {
+		return 0, fmt.Errorf("invalid interval %q", value)
+	}
+	parts := strings.Split(value, ":")
+	if leadingUnit == "DAY" && len(parts) > 1 && len(units) == 4 {
+		units = units[1:]
+	}
+	if len(parts) > len(units) {
+		return 0, fmt.Errorf("invalid interval %q", value)
+	}
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q", value)
+		}
+		seconds += number * units[i]
+	}
+	return sign * seconds, nil
+}
+
+// Function to cast the NUMERIC columns of a query which may be wider than a float64 to
+// VARCHAR, so that their values keep all their digits instead of being scanned as float64
+// by the driver. The columns are looked up with a LIMIT 0 query, and the query is returned
+// as is when it has no such column or its columns can't be selected by name.
+func castWideNumerics(ctx context.Context, connection *sql.Conn, rawSQL string) string {
+
+	log.DefaultLogger.Debug("Inside types.castWideNumerics Function")
+
+	// The query is wrapped on its own lines, so that a trailing comment doesn't hide the end of the wrapping query.
+	subquery := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(rawSQL), ";"))
+	rows, err := connection.QueryContext(ctx, fmt.Sprintf("SELECT * FROM (\n%s\n) AS grafana_numeric LIMIT 0", subquery))
+	if err != nil {
+		log.DefaultLogger.Warn("Could not look up the NUMERIC columns, they are returned as floats: " + err.Error())
+		return rawSQL
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		log.DefaultLogger.Warn("Could not look up the NUMERIC columns, they are returned as floats: " + err.Error())
+		return rawSQL
+	}
+
+	cast := false
+	names := make(map[string]bool, len(columnTypes))
+	columns := make([]string, len(columnTypes))
+	for idx, columnType := range columnTypes {
+		// Identifiers are case insensitive in Vertica, even when quoted.
+		name := strings.ToLower(columnType.Name())
+		if name == "" || names[name] {
+			log.DefaultLogger.Warn("The columns of the query don't have unique names, the NUMERIC columns are returned as floats")
+			return rawSQL
+		}
+		names[name] = true
+
+		columns[idx] = `"` + strings.ReplaceAll(columnType.Name(), `"`, `""`) + `"`
+		if isWideNumeric(columnType) {
+			columns[idx] += "::VARCHAR AS " + columns[idx]
+			cast = true
+		}
+	}
+	if !cast {
+		return rawSQL
+	}
+	return fmt.Sprintf("SELECT %s FROM (\n%s\n) AS grafana_numeric", strings.Join(columns, ", "), subquery)
+}
+
+// Function to check whether a column is a NUMERIC which may have more digits than a
+// float64 holds, including the ones whose precision is unknown.
+func isWideNumeric(columnType databaseColumnType) bool {
+	switch strings.ToUpper(columnType.DatabaseTypeName()) {
+	case "NUMERIC", "DECIMAL":
+		precision, _, ok := columnType.DecimalSize()
+		return !ok || precision > float64Digits
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+type testColumnType struct {
+	typeName  string
+	precision int64
+	scale     int64
+	ok        bool
+}
+
+func (c testColumnType) DatabaseTypeName() string {
+	return c.typeName
+}
+
+func (c testColumnType) DecimalSize() (int64, int64, bool) {
+	return c.precision, c.scale, c.ok
+}
+
+func Test_MapColumnType(t *testing.T) {
+
+	fmt.Println("Column Type Mapping Tests")
+
+	decimals := func(d uint16) *uint16 { return &d }
+	filterable := true
+
+	tests := []struct {
+		name           string
+		columnType     testColumnType
+		expectedType   string
+		expectedConfig *data.FieldConfig
+	}{
+		{name: "Integer", columnType: testColumnType{typeName: "INT"}, expectedType: "INTEGER"},
+		{name: "Float", columnType: testColumnType{typeName: "FLOAT"}, expectedType: "FLOAT"},
+		{name: "Date", columnType: testColumnType{typeName: "DATE"}, expectedType: "TIME"},
+		{name: "Time with time zone", columnType: testColumnType{typeName: "TIMETZ"}, expectedType: "TIME"},
+		{name: "Timestamp", columnType: testColumnType{typeName: "TIMESTAMPTZ"}, expectedType: "TIME"},
+		{name: "Boolean", columnType: testColumnType{typeName: "BOOL"}, expectedType: "BOOLEAN"},
+		{name: "Varchar", columnType: testColumnType{typeName: "VARCHAR"}, expectedType: "STRING"},
+		{name: "UUID", columnType: testColumnType{typeName: "UUID"}, expectedType: "STRING", expectedConfig: &data.FieldConfig{Filterable: &filterable}},
+		{name: "Interval day to second", columnType: testColumnType{typeName: "INTERVAL DAY TO SECOND"}, expectedType: "DURATION", expectedConfig: &data.FieldConfig{Unit: "s"}},
+		{name: "Interval year to month", columnType: testColumnType{typeName: "INTERVAL YEAR TO MONTH"}, expectedType: "DURATION", expectedConfig: &data.FieldConfig{Unit: "s"}},
+		{name: "Numeric", columnType: testColumnType{typeName: "NUMERIC", precision: 10, scale: 2, ok: true}, expectedType: "FLOAT", expectedConfig: &data.FieldConfig{Decimals: decimals(2)}},
+		{name: "Numeric without type modifier", columnType: testColumnType{typeName: "NUMERIC", precision: 1024, scale: 15, ok: true}, expectedType: "FLOAT", expectedConfig: &data.FieldConfig{}},
+		{name: "Numeric exceeding a float", columnType: testColumnType{typeName: "NUMERIC", precision: 37, scale: 4, ok: true}, expectedType: "FLOAT", expectedConfig: &data.FieldConfig{Decimals: decimals(4)}},
+		{name: "Unknown", columnType: testColumnType{typeName: "GEOMETRY"}, expectedType: "UNKNOWN"},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			columnType, config := mapColumnType(tc.columnType)
+			require.Equal(tt, tc.expectedType, columnType, "Invalid field type")
+			require.Equal(tt, tc.expectedConfig, config, "Invalid field config")
+		})
+	}
+}
+
+func Test_ParseInterval(t *testing.T) {
+
+	fmt.Println("Interval Parsing Tests")
+
+	tests := []struct {
+		value         string
+		intervalRange string
+		expected      float64
+		expectingErr  bool
+	}{
+		{value: "1 02:03:04.5", intervalRange: "DAY TO SECOND", expected: 93784.5},
+		{value: "-1 02:03:04", intervalRange: "DAY TO SECOND", expected: -93784},
+		{value: "02:03:04", intervalRange: "DAY TO SECOND", expected: 7384},
+		{value: "3", intervalRange: "DAY", expected: 259200},
+		{value: "1 02:03", intervalRange: "DAY TO MINUTE", expected: 93780},
+		{value: "26:30", intervalRange: "HOUR TO MINUTE", expected: 95400},
+		{value: "03:04.25", intervalRange: "MINUTE TO SECOND", expected: 184.25},
+		{value: "90", intervalRange: "SECOND", expected: 90},
+		{value: "1-06", intervalRange: "YEAR TO MONTH", expected: 18 * secondsPerMonth},
+		{value: "2", intervalRange: "YEAR", expected: 2 * secondsPerYear},
+		{value: "14", intervalRange: "MONTH", expected: 14 * secondsPerMonth},
+		{value: "1 02:03:04:05", intervalRange: "DAY TO SECOND", expectingErr: true},
+		{value: "a day", intervalRange: "DAY TO SECOND", expectingErr: true},
+		{value: "", intervalRange: "DAY TO SECOND", expectingErr: true},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Value:", tc.value, tc.intervalRange)
+
+		t.Run(tc.value+" "+tc.intervalRange, func(tt *testing.T) {
+			seconds, err := parseInterval(tc.value, tc.intervalRange)
+			if tc.expectingErr {
+				require.NotNil(tt, err, "Invalid interval should throw an error")
+				return
+			}
+			require.Nil(tt, err, "Valid interval shouldn't throw any errors")
+			require.InDelta(tt, tc.expected, seconds, 1e-9, "Invalid number of seconds")
+		})
+	}
+}
+
+func Test_Query_ColumnTypes(t *testing.T) {
+
+	fmt.Println("Query Column Types Tests")
+
+	db, mock, err := sqlmock.New()
+	require.Nil(t, err)
+	defer db.Close()
+
+	day := time.Date(2021, 01, 05, 00, 00, 00, 00, time.UTC)
+	mockRows := mock.NewRowsWithColumnDefinition(
+		mock.NewColumn("day").OfType("DATE", day),
+		mock.NewColumn("elapsed").OfType("INTERVAL DAY TO SECOND", ""),
+		mock.NewColumn("amount").OfType("NUMERIC", 0.0).WithPrecisionAndScale(37, 2),
+		mock.NewColumn("id").OfType("UUID", ""),
+	).AddRow(day, "1 00:00:30", 12.5, "2f1b6c4e-5b1c-4d7a-9d6e-0f3b1a2c3d4e").AddRow(nil, "bad interval", nil, nil)
+	mock.ExpectQuery("SELECT day, elapsed, amount, id FROM test_table").WillReturnRows(mockRows)
+
+	v := &VerticaDatasource{}
+	queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: "SELECT day, elapsed, amount, id FROM test_table", Format: "table"}), &instanceSettings{Db: db}, nil)
+
+	require.Nil(t, queryOutput.Error, "Query shouldn't throw any errors")
+	fields := queryOutput.Frames[0].Fields
+	require.Len(t, fields, 4, "Invalid number of fields created")
+
+	require.Equal(t, data.FieldTypeNullableTime, fields[0].Type(), "DATE should be mapped to a time field")
+	require.Equal(t, day, *fields[0].At(0).(*time.Time), "Invalid date value")
+
+	require.Equal(t, data.FieldTypeNullableFloat64, fields[1].Type(), "INTERVAL should be mapped to a float field")
+	require.Equal(t, "s", fields[1].Config.Unit, "INTERVAL should be in seconds")
+	require.Equal(t, 86430.0, *fields[1].At(0).(*float64), "Invalid interval value")
+	require.Nil(t, fields[1].At(1), "Invalid interval should be NULL")
+
+	require.Equal(t, data.FieldTypeNullableFloat64, fields[2].Type(), "NUMERIC should be mapped to a float field")
+	require.Equal(t, 12.5, *fields[2].At(0).(*float64), "Invalid numeric value")
+	require.Equal(t, uint16(2), *fields[2].Config.Decimals, "NUMERIC should keep the scale of the column")
+
+	require.Equal(t, data.FieldTypeNullableString, fields[3].Type(), "UUID should be mapped to a string field")
+	require.True(t, *fields[3].Config.Filterable, "UUID should be filterable")
+}
+
+func Test_Query_NumericAsString(t *testing.T) {
+
+	fmt.Println("Query Numeric As String Tests")
+
+	rawSQL := "SELECT id, amount, price FROM test_table;"
+	probeSQL := "SELECT * FROM (\nSELECT id, amount, price FROM test_table\n) AS grafana_numeric LIMIT 0"
+
+	tests := []struct {
+		name         string
+		columns      []string
+		precisions   []int64
+		probeErr     error
+		expectedSQL  string
+		expectedType data.FieldType
+	}{
+		{
+			name:         "Wide NUMERIC columns are cast to VARCHAR",
+			columns:      []string{"id", "amount", "price"},
+			precisions:   []int64{0, 37, 1024},
+			expectedSQL:  "SELECT \"id\", \"amount\"::VARCHAR AS \"amount\", \"price\"::VARCHAR AS \"price\" FROM (\nSELECT id, amount, price FROM test_table\n) AS grafana_numeric",
+			expectedType: data.FieldTypeNullableString,
+		},
+		{
+			name:         "NUMERIC columns fitting a float are kept",
+			columns:      []string{"id", "amount", "price"},
+			precisions:   []int64{0, 15, 10},
+			expectedSQL:  rawSQL,
+			expectedType: data.FieldTypeNullableFloat64,
+		},
+		{
+			name:         "Columns without unique names are kept",
+			columns:      []string{"id", "amount", "AMOUNT"},
+			precisions:   []int64{0, 37, 37},
+			expectedSQL:  rawSQL,
+			expectedType: data.FieldTypeNullableFloat64,
+		},
+		{
+			name:         "Queries which can't be wrapped are kept",
+			columns:      []string{"id", "amount", "price"},
+			precisions:   []int64{0, 37, 37},
+			probeErr:     fmt.Errorf("syntax error"),
+			expectedSQL:  rawSQL,
+			expectedType: data.FieldTypeNullableFloat64,
+		},
+	}
+
+	for _, tc := range tests {
+		fmt.Println("Test Name:", tc.name)
+
+		t.Run(tc.name, func(tt *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.Nil(tt, err)
+			defer db.Close()
+
+			newColumns := func() []*sqlmock.Column {
+				columns := []*sqlmock.Column{mock.NewColumn(tc.columns[0]).OfType("INT", int64(0))}
+				for idx, name := range tc.columns[1:] {
+					columns = append(columns, mock.NewColumn(name).OfType("NUMERIC", 0.0).WithPrecisionAndScale(tc.precisions[idx+1], 2))
+				}
+				return columns
+			}
+			if tc.probeErr != nil {
+				mock.ExpectQuery(probeSQL).WillReturnError(tc.probeErr)
+			} else {
+				mock.ExpectQuery(probeSQL).WillReturnRows(mock.NewRowsWithColumnDefinition(newColumns()...))
+			}
+			resultRows := mock.NewRowsWithColumnDefinition(newColumns()...)
+			if tc.expectedType == data.FieldTypeNullableString {
+				resultRows = mock.NewRowsWithColumnDefinition(
+					mock.NewColumn("id").OfType("INT", int64(0)),
+					mock.NewColumn("amount").OfType("VARCHAR", ""),
+					mock.NewColumn("price").OfType("VARCHAR", ""),
+				).AddRow(int64(1), "12345678901234567890.12", "0.10")
+			} else {
+				resultRows.AddRow(int64(1), 12.5, 0.1)
+			}
+			mock.ExpectQuery(tc.expectedSQL).WillReturnRows(resultRows)
+
+			v := &VerticaDatasource{}
+			queryOutput := v.query(context.Background(), getDataQuery(queryModel{RawSQL: rawSQL, Format: "table"}), &instanceSettings{Db: db, config: configArgs{NumericAsString: true}}, nil)
+
+			require.Nil(tt, queryOutput.Error, "Query shouldn't throw any errors")
+			require.Nil(tt, mock.ExpectationsWereMet(), "The NUMERIC columns should be looked up before the query")
+			require.Equal(tt, tc.expectedSQL, queryOutput.Frames[0].Meta.ExecutedQueryString, "Invalid executed query")
+			require.Equal(tt, tc.expectedType, queryOutput.Frames[0].Fields[1].Type(), "Invalid NUMERIC field type")
+			if tc.expectedType == data.FieldTypeNullableString {
+				require.Equal(tt, "12345678901234567890.12", *queryOutput.Frames[0].Fields[1].At(0).(*string), "Wide NUMERIC should keep all its digits")
+			}
+		})
+	}
+}
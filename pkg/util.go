@@ -35,6 +35,8 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
@@ -85,6 +87,31 @@ func contains(slice []string, element string) bool {
 	return false
 }
 
+// Function to split a possibly schema qualified identifier on the first dot
+// which is not part of a quoted identifier.
+func splitQualifiedIdentifier(value string) []string {
+	inQuotes := false
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case '.':
+			if !inQuotes {
+				return []string{value[:i], value[i+1:]}
+			}
+		}
+	}
+	return []string{value}
+}
+
+// Function to convert a quoted identifier into the name stored in the catalog.
+func unquoteIdentifier(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return strings.ReplaceAll(value[1:len(value)-1], `""`, `"`)
+	}
+	return value
+}
+
 // ParseDuration parses a duration string.
 // A duration string is a possibly signed sequence of
 // decimal numbers, each with optional fraction and a unit suffix,
@@ -245,3 +272,30 @@ func leadingFraction(s string) (x int64, scale float64, rem string) {
 	}
 	return x, scale, s[i:]
 }
+
+// Function to format an interval the way Grafana formats the $__interval variable,
+// in the largest unit which fits the interval, e.g. 1m or 500ms.
+func formatInterval(interval time.Duration) string {
+	switch {
+	case interval >= 24*time.Hour:
+		return fmt.Sprintf("%dd", interval/(24*time.Hour))
+	case interval >= time.Hour:
+		return fmt.Sprintf("%dh", interval/time.Hour)
+	case interval >= time.Minute:
+		return fmt.Sprintf("%dm", interval/time.Minute)
+	case interval >= time.Second:
+		return fmt.Sprintf("%ds", interval/time.Second)
+	default:
+		return fmt.Sprintf("%dms", interval/time.Millisecond)
+	}
+}
+
+// Function to quote a string literal of a query.
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// Function to quote an identifier of a query.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
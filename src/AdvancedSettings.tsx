@@ -0,0 +1,314 @@
+import React, { ChangeEvent, useState } from 'react';
+import { Button, Field, IconButton, InlineLabel, Input, Select, Switch } from '@grafana/ui';
+import { MyDataSourceOptions, RoleMapping } from './types';
+
+export interface AdvancedSettingsProps {
+  jsonData: MyDataSourceOptions;
+  onChange: (jsonData: MyDataSourceOptions) => void;
+}
+
+// value of a number input, undefined when the input is empty so that the backend default applies
+const toNumber = (event: ChangeEvent<HTMLInputElement>): number | undefined =>
+  event.target.value === '' ? undefined : Number(event.target.value);
+
+interface DriverParamsProps {
+  value?: Record<string, string>;
+  onChange: (value?: Record<string, string>) => void;
+}
+
+// editor of the extra parameters of the driver, kept as a list while edited so that a
+// parameter can be renamed, parameters without a name are left out of the settings
+const DriverParams = ({ value, onChange }: DriverParamsProps): JSX.Element => {
+  const [params, setParams] = useState<Array<[string, string]>>(Object.entries(value || {}));
+  const update = (updated: Array<[string, string]>) => {
+    setParams(updated);
+    const driverParams = updated.reduce<Record<string, string>>((result, [name, paramValue]) => {
+      if (name.trim() !== '') {
+        result[name.trim()] = paramValue;
+      }
+      return result;
+    }, {});
+    onChange(Object.keys(driverParams).length > 0 ? driverParams : undefined);
+  };
+
+  return (
+    <>
+      {params.map(([name, paramValue], index) => (
+        <div className="gf-form-inline" key={index}>
+          <Input
+            width={24}
+            placeholder="name"
+            value={name}
+            onChange={(event: ChangeEvent<HTMLInputElement>) =>
+              update(params.map((param, i) => (i === index ? [event.target.value, param[1]] : param)))
+            }
+          />
+          <Input
+            width={30}
+            placeholder="value"
+            value={paramValue}
+            onChange={(event: ChangeEvent<HTMLInputElement>) =>
+              update(params.map((param, i) => (i === index ? [param[0], event.target.value] : param)))
+            }
+          />
+          <IconButton
+            name="trash-alt"
+            aria-label="Remove the parameter"
+            onClick={() => update(params.filter((_, i) => i !== index))}
+          />
+        </div>
+      ))}
+      <Button variant="secondary" size="sm" icon="plus" onClick={() => update([...params, ['', '']])}>
+        Add parameter
+      </Button>
+    </>
+  );
+};
+
+const GRAFANA_ROLE_OPTIONS = [
+  { label: 'Any role', value: '' },
+  { label: 'Viewer', value: 'Viewer' },
+  { label: 'Editor', value: 'Editor' },
+  { label: 'Admin', value: 'Admin' },
+];
+
+interface RoleMappingsProps {
+  value?: RoleMapping[];
+  onChange: (value?: RoleMapping[]) => void;
+}
+
+// editor of the mappings of the Grafana organizations and roles to the Vertica roles
+const RoleMappings = ({ value = [], onChange }: RoleMappingsProps): JSX.Element => {
+  const update = (index: number, changed: Partial<RoleMapping>) =>
+    onChange(value.map((mapping, i) => (i === index ? { ...mapping, ...changed } : mapping)));
+
+  return (
+    <>
+      {value.map((mapping, index) => (
+        <div className="gf-form-inline" key={index}>
+          <Input
+            type="number"
+            min={0}
+            width={16}
+            placeholder="any org id"
+            value={mapping.orgId || ''}
+            onChange={(event: ChangeEvent<HTMLInputElement>) =>
+              update(index, { orgId: event.target.value === '' ? undefined : Number(event.target.value) })
+            }
+          />
+          <Select
+            width={16}
+            options={GRAFANA_ROLE_OPTIONS}
+            value={mapping.grafanaRole || ''}
+            onChange={(option) => update(index, { grafanaRole: option.value || undefined })}
+          />
+          <Input
+            width={30}
+            placeholder="Vertica role"
+            value={mapping.verticaRole}
+            onChange={(event: ChangeEvent<HTMLInputElement>) => update(index, { verticaRole: event.target.value })}
+          />
+          <IconButton
+            name="trash-alt"
+            aria-label="Remove the role mapping"
+            onClick={() => {
+              const mappings = value.filter((_, i) => i !== index);
+              onChange(mappings.length > 0 ? mappings : undefined);
+            }}
+          />
+        </div>
+      ))}
+      <Button variant="secondary" size="sm" icon="plus" onClick={() => onChange([...value, { verticaRole: '' }])}>
+        Add role mapping
+      </Button>
+    </>
+  );
+};
+
+// settings of the data source which tune how the queries are run
+export const AdvancedSettings = ({ jsonData, onChange }: AdvancedSettingsProps): JSX.Element => {
+  return (
+    <>
+      <div className="gf-form-group">
+        <b>Query Limits</b>
+        <div className="gf-form">
+          <Field
+            label="Query Timeout"
+            description="Time after which a query is cancelled, in seconds, no timeout when empty. Queries can set their own."
+          >
+            <Input
+              type="number"
+              min={0}
+              width={20}
+              placeholder="seconds"
+              value={jsonData.queryTimeout ?? ''}
+              onChange={(event: ChangeEvent<HTMLInputElement>) =>
+                onChange({ ...jsonData, queryTimeout: toNumber(event) })
+              }
+            />
+          </Field>
+        </div>
+        <div className="gf-form">
+          <Field
+            label="Max Concurrent Queries"
+            description="Maximum number of queries of a request run at the same time, 10 when empty"
+          >
+            <Input
+              type="number"
+              min={0}
+              width={20}
+              placeholder="10"
+              value={jsonData.maxConcurrentQueries ?? ''}
+              onChange={(event: ChangeEvent<HTMLInputElement>) =>
+                onChange({ ...jsonData, maxConcurrentQueries: toNumber(event) })
+              }
+            />
+          </Field>
+        </div>
+        <div className="gf-form">
+          <Field
+            label="Max Rows"
+            description="Maximum number of rows of the result of a query, which is truncated with a notice, no limit when empty"
+          >
+            <Input
+              type="number"
+              min={0}
+              width={20}
+              placeholder="rows"
+              value={jsonData.maxRows ?? ''}
+              onChange={(event: ChangeEvent<HTMLInputElement>) =>
+                onChange({ ...jsonData, maxRows: toNumber(event) })
+              }
+            />
+          </Field>
+        </div>
+        <div className="gf-form">
+          <Field
+            label="Max Response Bytes"
+            description="Maximum size of the result of a query, which is truncated with a notice, no limit when empty"
+          >
+            <Input
+              type="number"
+              min={0}
+              width={20}
+              placeholder="bytes"
+              value={jsonData.maxResponseBytes ?? ''}
+              onChange={(event: ChangeEvent<HTMLInputElement>) =>
+                onChange({ ...jsonData, maxResponseBytes: toNumber(event) })
+              }
+            />
+          </Field>
+        </div>
+      </div>
+      <div className="gf-form-group">
+        <b>Data Types</b>
+        <div className="gf-form">
+          <InlineLabel
+            width={30}
+            tooltip="If set, the NUMERIC columns wider than a float are cast to VARCHAR, to keep all their digits"
+          >
+            Numeric As String
+          </InlineLabel>
+          <div className="gf-form-switch">
+            <Switch
+              value={!!jsonData.numericAsString}
+              onChange={(event: ChangeEvent<HTMLInputElement>) =>
+                onChange({ ...jsonData, numericAsString: event.target.checked })
+              }
+            />
+          </div>
+        </div>
+        <div className="gf-form">
+          <Field
+            label="Database Time Zone"
+            description="Time zone of the local times held by the TIMESTAMP columns, used by the time macros"
+          >
+            <Input
+              width={30}
+              placeholder="America/New_York"
+              value={jsonData.databaseTimezone || ''}
+              onChange={(event: ChangeEvent<HTMLInputElement>) =>
+                onChange({ ...jsonData, databaseTimezone: event.target.value.trim() || undefined })
+              }
+            />
+          </Field>
+        </div>
+      </div>
+      <div className="gf-form-group">
+        <b>User Identity</b>
+        <div className="gf-form">
+          <InlineLabel
+            width={30}
+            tooltip="If set, the queries of each Grafana user signed in with OAuth run with the forwarded OAuth token of the user"
+          >
+            Forward OAuth Identity
+          </InlineLabel>
+          <div className="gf-form-switch">
+            <Switch
+              value={!!jsonData.oauthPassThru}
+              onChange={(event: ChangeEvent<HTMLInputElement>) =>
+                onChange({ ...jsonData, oauthPassThru: event.target.checked })
+              }
+            />
+          </div>
+        </div>
+        <div className="gf-form">
+          <Field
+            label="Max User Pools"
+            description="Maximum number of connection pools kept for the forwarded users, 20 when empty"
+            disabled={!jsonData.oauthPassThru}
+          >
+            <Input
+              type="number"
+              min={0}
+              width={20}
+              placeholder="20"
+              value={jsonData.maxUserPools ?? ''}
+              onChange={(event: ChangeEvent<HTMLInputElement>) =>
+                onChange({ ...jsonData, maxUserPools: toNumber(event) })
+              }
+            />
+          </Field>
+        </div>
+      </div>
+      <div className="gf-form-group">
+        <b>Session Attribution</b>
+        <div className="gf-form">
+          <InlineLabel
+            width={30}
+            tooltip="If set, the client label of the session of each query names the organization, user, dashboard and panel of the query"
+          >
+            Set Client Label
+          </InlineLabel>
+          <div className="gf-form-switch">
+            <Switch
+              value={!!jsonData.setClientLabel}
+              onChange={(event: ChangeEvent<HTMLInputElement>) =>
+                onChange({ ...jsonData, setClientLabel: event.target.checked })
+              }
+            />
+          </div>
+        </div>
+        <p>
+          Vertica role set for the queries of the users of a Grafana organization, of a Grafana role, or both. The first
+          matching mapping is used. Grafana teams are not sent to the data sources, so they cannot be mapped.
+        </p>
+        <RoleMappings
+          value={jsonData.roleMappings}
+          onChange={(roleMappings) => onChange({ ...jsonData, roleMappings })}
+        />
+      </div>
+      <div className="gf-form-group">
+        <b>Driver Parameters</b>
+        <p>
+          Extra parameters of the vertica-sql-go driver, such as <code>client_label</code> or <code>autocommit</code>.
+          They cannot override the parameters set by the fields above.
+        </p>
+        <DriverParams
+          value={jsonData.driverParams}
+          onChange={(driverParams) => onChange({ ...jsonData, driverParams })}
+        />
+      </div>
+    </>
+  );
+};
@@ -4,6 +4,7 @@ import { InlineField, InfoBox, InlineLabel, Switch, LegacyForms, Select, Field,
 import { DataSourcePluginOptionsEditorProps, FeatureToggles, SelectableValue } from '@grafana/data';
 import { MyDataSourceOptions, MySecureJsonData, FIELD_TYPES } from './types';
 import { SSL_MODE_OPTIONS } from './constants';
+import { AdvancedSettings } from './AdvancedSettings';
 import { config } from '@grafana/runtime';
 
 const { SecretFormField, FormField } = LegacyForms;
@@ -194,6 +195,11 @@ export class ConfigEditor extends PureComponent<Props, State> {
     onOptionsChange({ ...options, jsonData });
   };
 
+  onJsonDataChange = (jsonData: MyDataSourceOptions) => {
+    const { onOptionsChange, options } = this.props;
+    onOptionsChange({ ...options, jsonData });
+  };
+
   render() {
     const { options } = this.props;
     const { jsonData, secureJsonFields } = options;
@@ -384,6 +390,7 @@ export class ConfigEditor extends PureComponent<Props, State> {
             </Field>
           </div>
         </div>
+        <AdvancedSettings jsonData={jsonData} onChange={this.onJsonDataChange} />
         <div className="gf-form-group">
           <InfoBox title="User Permission">
             <p>
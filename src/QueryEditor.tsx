@@ -1,7 +1,6 @@
 import React, { useState, useMemo, useCallback, useEffect, useRef } from 'react';
-import { Select, InlineLabel, SegmentAsync, ConfirmModal, useTheme, Button } from '@grafana/ui';
+import { Select, InlineLabel, SegmentAsync, ConfirmModal, useTheme, Button, Input, InlineSwitch } from '@grafana/ui';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
-import { MetaQuery } from './meta_query';
 import QueryModel from './query_model';
 import { MyDataSourceOptions, MyQuery } from './types';
 import { getTemplateSrv } from '@grafana/runtime';
@@ -103,6 +102,17 @@ export const QueryEditor = (props: Props): JSX.Element => {
     onApplyQueryChange({ ...query, format: value.value });
     // }
   };
+  // handler for the timeout of the query, the timeout of the data source applies when it is empty
+  const onQueryTimeoutChange = (event: React.FocusEvent<HTMLInputElement>) => {
+    const queryTimeout = event.target.value === '' ? undefined : Number(event.target.value);
+    if (queryTimeout !== query.queryTimeout) {
+      onApplyQueryChange({ ...query, queryTimeout });
+    }
+  };
+  // handler for the expansion of the ARRAY, SET and ROW columns into rows and columns
+  const onExpandComplexTypesChange = (event: React.FormEvent<HTMLInputElement>) => {
+    onApplyQueryChange({ ...query, expandComplexTypes: event.currentTarget.checked });
+  };
   const onCommonApplyAQuery = (changedQuery: MyQuery, runQuery = true) => {};
   // method to show the confirm prompt when user clicks on "Query Builder" button from raw sql mode
   const showConfirmPrompt = () => {
@@ -376,13 +386,11 @@ export const QueryEditor = (props: Props): JSX.Element => {
 
   // method to fetch all the schemas available in the configured datasource
   const getSchemaOptions = () => {
-    const queryModel = new QueryModel(query, getTemplateSrv());
-    const metaBuilder = new MetaQuery(query, queryModel);
     return new Promise<any[]>((resolve) => {
       setTimeout(async () => {
-        const response = await datasource.metricFindQuery(metaBuilder.buildSchemaQuery());
-        const result = response.map((res: any) => {
-          return { label: res.text, value: res.text };
+        const response = await datasource.getSchemas();
+        const result = response.map((res: string) => {
+          return { label: res, value: res };
         });
         resolve(result);
       }, 0);
@@ -391,13 +399,11 @@ export const QueryEditor = (props: Props): JSX.Element => {
 
   // method to fetch all the tables for the selected schema
   const getTableOptions = () => {
-    const queryModel = new QueryModel(query, getTemplateSrv());
-    const metaBuilder = new MetaQuery(query, queryModel);
     return new Promise<any[]>((resolve) => {
       setTimeout(async () => {
-        const response = await datasource.metricFindQuery(metaBuilder.buildTableQuery());
-        const result = response.map((res: any) => {
-          return { label: res.text, value: res.text };
+        const response = await datasource.getTables(query.schema);
+        const result = response.map((res: string) => {
+          return { label: res, value: res };
         });
         resolve(result);
       }, 0);
@@ -408,13 +414,11 @@ export const QueryEditor = (props: Props): JSX.Element => {
 
   // method to fetch all the time columns for the selected table
   const getTimeColumnOptions = () => {
-    const queryModel = new QueryModel(query, getTemplateSrv());
-    const metaBuilder = new MetaQuery(query, queryModel);
     return new Promise<any[]>((resolve) => {
       setTimeout(async () => {
-        const response = await datasource.metricFindQuery(metaBuilder.buildColumnQuery('time'));
-        const result = response.map((res: any) => {
-          return { label: res.text, value: res.text };
+        const response = await datasource.getColumns(query.schema, query.table, 'time');
+        const result = response.map((res: string) => {
+          return { label: res, value: res };
         });
         resolve(result);
       }, 0);
@@ -423,13 +427,11 @@ export const QueryEditor = (props: Props): JSX.Element => {
 
   // method to fetch all the metric columns for the selected table
   const getMetricColumnOptions = () => {
-    const queryModel = new QueryModel(query, getTemplateSrv());
-    const metaBuilder = new MetaQuery(query, queryModel);
     return new Promise<any[]>((resolve) => {
       setTimeout(async () => {
-        const response = await datasource.metricFindQuery(metaBuilder.buildColumnQuery('metric'));
-        const result = response.map((res: any) => {
-          return { label: res.text, value: res.text };
+        const response = await datasource.getColumns(query.schema, query.table, 'metric');
+        const result = response.map((res: string) => {
+          return { label: res, value: res };
         });
         resolve(result);
       }, 0);
@@ -438,15 +440,10 @@ export const QueryEditor = (props: Props): JSX.Element => {
 
   // method to fetch all the value columns for the selected table
   const getColumnOptions = useCallback(async () => {
-    const queryModel = new QueryModel(query, getTemplateSrv());
-    const metaBuilder = new MetaQuery(query, queryModel);
     return new Promise<any[]>((resolve) => {
       setTimeout(async () => {
-        const response = await datasource.metricFindQuery(metaBuilder.buildColumnQuery('value'));
-        const result = response.map((res: any) => {
-          return res.text;
-        });
-        resolve(result);
+        const response = await datasource.getColumns(query.schema, query.table, 'value', query.timeColumn);
+        resolve(response);
       }, 0);
     });
   }, [datasource, query]);
@@ -490,15 +487,10 @@ export const QueryEditor = (props: Props): JSX.Element => {
   // method to fetch all the columns for the selected table
   const getAllColumns = useCallback(() => {
     const { datasource } = props;
-    const queryModel = new QueryModel(query, getTemplateSrv());
-    const metaBuilder = new MetaQuery(query, queryModel);
     return new Promise<any[]>((resolve) => {
       setTimeout(async () => {
-        const response = await datasource.metricFindQuery(metaBuilder.buildColumnQuery(''));
-        const result = response.map((res: any) => {
-          return res.text;
-        });
-        resolve(result);
+        const response = await datasource.getColumns(query.schema, query.table);
+        resolve(response);
       }, 0);
     });
   }, [props, query]);
@@ -841,6 +833,34 @@ export const QueryEditor = (props: Props): JSX.Element => {
           </div>
         </div>
       )}
+      <div className="gf-form-inline">
+        <InlineLabel
+          style={divStyle}
+          width={14}
+          tooltip="Time after which the query is cancelled, in seconds. The timeout of the data source applies when it is empty."
+        >
+          Timeout
+        </InlineLabel>
+        <Input
+          type="number"
+          min={0}
+          width={16}
+          placeholder="seconds"
+          defaultValue={query.queryTimeout}
+          onBlur={onQueryTimeoutChange}
+        />
+        <InlineLabel
+          style={divStyle}
+          width={24}
+          tooltip="Expand the elements of the ARRAY and SET columns into rows, and the fields of the ROW columns into columns, instead of returning them as JSON."
+        >
+          Expand complex types
+        </InlineLabel>
+        <InlineSwitch value={!!query.expandComplexTypes} onChange={onExpandComplexTypesChange} />
+        <div className="gf-form gf-form--grow">
+          <label className="gf-form-label gf-form-label--grow"></label>
+        </div>
+      </div>
       <ConfirmModal
         isOpen={isModalOpen}
         title="Warning"
@@ -20,8 +20,9 @@ export class QueryEditorHelp extends React.PureComponent<Props> {
         <br />- return any set of columns <br />
         <br />
         Macros: <br />- $__time(column) -&gt; UNIX_TIMESTAMP(column) as time_sec <br />- $__timeEpoch(column) -&gt;
-        UNIX_TIMESTAMP(column) as time_sec <br />- $__timeFilter(column) -&gt; column BETWEEN FROM_UNIXTIME(1492750877)
-        AND FROM_UNIXTIME(1492750877) <br />- $__unixEpochFilter(column) -&gt; time_unix_epoch &gt; 1492750877 AND
+        UNIX_TIMESTAMP(column) as time_sec <br />- $__timeFilter(column[, &apos;time zone&apos;]) -&gt; column BETWEEN
+        FROM_UNIXTIME(1492750877) AND FROM_UNIXTIME(1492750877), with a time zone the bounds are converted with AT TIME
+        ZONE to the local time of that zone <br />- $__unixEpochFilter(column) -&gt; time_unix_epoch &gt; 1492750877 AND
         time_unix_epoch &lt; 1492750877 <br />- $__unixEpochNanoFilter(column) -&gt; column &gt;= 1494410783152415214
         AND column &lt;= 1494497183142514872 <br />- $__timeGroup(column,&apos;5m&apos;[, fillvalue]) -&gt;
         cast(cast(UNIX_TIMESTAMP(column)/(300) as signed)*300 as signed) by setting fillvalue grafana will fill in
@@ -29,7 +30,12 @@ export class QueryEditorHelp extends React.PureComponent<Props> {
         will fill in the previous seen value or NULL if none has been seen yet <br />-
         $__timeGroupAlias(column,&apos;5m&apos;) -&gt; cast(cast(UNIX_TIMESTAMP(column)/(300) as signed)*300 as signed)
         AS &quot;time&quot; <br />- $__unixEpochGroup(column,&apos;5m&apos;) -&gt; column DIV 300 * 300 <br />-
-        $__unixEpochGroupAlias(column,&apos;5m&apos;) -&gt; column DIV 300 * 300 AS &quot;time&quot; <br />
+        $__unixEpochGroupAlias(column,&apos;5m&apos;) -&gt; column DIV 300 * 300 AS &quot;time&quot; <br />-
+        $__timeSlice(column,&apos;5m&apos;[, START|END]) -&gt; TIME_SLICE(column, 300, &apos;SECOND&apos;,
+        &apos;START&apos;) as time <br />- $__timeseries(column,&apos;5m&apos;[, partition]) -&gt; TIMESERIES slice_time AS
+        &apos;300 seconds&apos; OVER (PARTITION BY partition ORDER BY column) <br />- $__tsValue(column[, linear|const])
+        -&gt; TS_FIRST_VALUE(column, &apos;CONST&apos;) <br />- $__tsLastValue(column[, linear|const]) -&gt;
+        TS_LAST_VALUE(column, &apos;CONST&apos;) <br />
         <br />
         Example of group by and order by with $__timeGroup:
         <br />
@@ -40,10 +46,11 @@ export class QueryEditorHelp extends React.PureComponent<Props> {
         GROUP BY 1 <br />
         ORDER BY 1<br />
         <br />
-        Or build your own conditionals using these macros which just return the values: <br />- $__timeFrom() -&gt;
-        FROM_UNIXTIME(1492750877) <br />- $__timeTo() -&gt; FROM_UNIXTIME(1492750877) <br />- $__unixEpochFrom() -&gt;
+        Or build your own conditionals using these macros which just return the values: <br />- $__timeFrom([&apos;time
+        zone&apos;]) -&gt; FROM_UNIXTIME(1492750877) <br />- $__timeTo([&apos;time zone&apos;]) -&gt; FROM_UNIXTIME(1492750877) <br />- $__unixEpochFrom() -&gt;
         1492750877 <br />- $__unixEpochTo() -&gt; 1492750877 <br />- $__unixEpochNanoFrom() -&gt; 1494410783152415214{' '}
-        <br />- $__unixEpochNanoTo() -&gt; 1494497183142514872
+        <br />- $__unixEpochNanoTo() -&gt; 1494497183142514872 <br />- $__interval -&gt; 30s <br />- $__interval_ms -&gt;
+        30000 <br />- $__maxDataPoints -&gt; 1000
       </pre>
     );
   }
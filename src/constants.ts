@@ -40,6 +40,7 @@ export const WHERE_OPTIONS = [
 
 export const FORMAT_OPTIONS = [
   { label: 'Time Series', value: 'time_series' },
+  { label: 'Time Series (multi-frame)', value: 'time_series_multi' },
   { label: 'Table', value: 'table' },
 ];
 
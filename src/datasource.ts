@@ -35,6 +35,9 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
           intervalMs: options.intervalMs,
           maxDataPoints: options.maxDataPoints,
           format: target.format,
+          queryTimeout: target.queryTimeout,
+          expandComplexTypes: target.expandComplexTypes,
+          timezone: options.timezone,
         };
       });
     return super.query(options);
@@ -59,6 +62,21 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
     return quotedValues.join(',');
   }
 
+  // fetches the schemas from the catalog resource endpoint of the backend
+  async getSchemas(): Promise<string[]> {
+    return this.getResource('schemas');
+  }
+
+  // fetches the tables of a schema from the catalog resource endpoint of the backend
+  async getTables(schema: string): Promise<string[]> {
+    return this.getResource('tables', { schema });
+  }
+
+  // fetches the columns of a table, optionally restricted to the time, metric or value columns
+  async getColumns(schema: string, table: string, kind?: string, timeColumn?: string): Promise<string[]> {
+    return this.getResource('columns', _.omitBy({ schema, table, kind, timeColumn }, _.isEmpty));
+  }
+
   async metricFindQuery(query: string, optionalOptions?: any) {
     const refId = optionalOptions?.variable?.name || 'tempVar';
 
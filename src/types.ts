@@ -7,7 +7,7 @@ export interface QueryPart {
   name?: string;
 }
 
-export type ResultFormat = 'time_series' | 'table';
+export type ResultFormat = 'time_series' | 'time_series_multi' | 'table';
 export interface MyQuery extends DataQuery {
   timeColumnType: string;
   timeGroup: QueryPart;
@@ -23,6 +23,18 @@ export interface MyQuery extends DataQuery {
   rawSql?: string;
   queryText?: string;
   hide: boolean;
+  queryTimeout?: number;
+
+  maxRows?: number;
+
+  maxResponseBytes?: number;
+
+  numericAsString?: boolean;
+
+  databaseTimezone?: string;
+
+  driverParams?: Record<string, string>;
+  expandComplexTypes?: boolean;
 }
 
 // eslint-disable-next-line @typescript-eslint/array-type
@@ -36,6 +48,15 @@ export type Part = {
   params: PartParams;
 };
 
+/**
+ * Mapping of the users of a Grafana organization and role to a Vertica role
+ */
+export interface RoleMapping {
+  orgId?: number;
+  grafanaRole?: string;
+  verticaRole: string;
+}
+
 /**
  * These are options configured for each DataSource instance
  */
@@ -48,6 +69,20 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
 
   tlsmode?: string;
 
+  tlsServerName?: string;
+
+  oauthTokenUrl?: string;
+
+  oauthClientId?: string;
+
+  oauthScopes?: string[];
+
+  maxUserPools?: number;
+
+  setClientLabel?: boolean;
+
+  roleMappings?: RoleMapping[];
+
   usePreparedStatements: boolean;
 
   useLoadBalancer: boolean;
@@ -66,6 +101,20 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
 
   enableSecureSocksProxy?: boolean;
 
+  maxConcurrentQueries?: number;
+
+  queryTimeout?: number;
+
+  maxRows?: number;
+
+  maxResponseBytes?: number;
+
+  numericAsString?: boolean;
+
+  databaseTimezone?: string;
+
+  driverParams?: Record<string, string>;
+
 }
 
 /**
@@ -74,6 +123,11 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
 export interface MySecureJsonData {
   password?: string;
   OauthToken?: string;
+  tlsCACert?: string;
+  tlsClientCert?: string;
+  tlsClientKey?: string;
+  oauthClientSecret?: string;
+  oauthRefreshToken?: string;
 }
 
 export const FIELD_TYPES = {